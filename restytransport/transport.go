@@ -0,0 +1,48 @@
+// Package restytransport sends the requests of the speedtest package through a resty client,
+// for callers which configure retries or middlewares on one. The speedtest package itself
+// does not depend on resty.
+package restytransport
+
+import (
+	"net/http"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Transport is a speedtest.Transport sending requests through a resty client.
+// Requests without a body go through resty, so that its retries and middlewares apply,
+// the others through the underlying http.Client, as resty would buffer their body.
+// Tests given a Transport send all of their requests through resty, see Tests.
+type Transport struct {
+	client *resty.Client
+}
+
+// New returns a Transport sending requests through client.
+func New(client *resty.Client) *Transport {
+	return &Transport{client: client}
+}
+
+// Tests returns the http.Client of the resty client, for tests to send their requests through,
+// so that streams cut at the end of a timed test are neither retried nor logged by resty.
+func (t *Transport) Tests() *http.Client {
+	return t.client.GetClient()
+}
+
+// Do sends req.
+func (t *Transport) Do(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		return t.client.GetClient().Do(req)
+	}
+
+	r := t.client.R().
+		SetContext(req.Context()).
+		SetDoNotParseResponse(true)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	resp, err := r.Execute(req.Method, req.URL.String())
+	if err != nil {
+		return nil, err
+	}
+	return resp.RawResponse, nil
+}
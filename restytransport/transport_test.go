@@ -0,0 +1,93 @@
+package restytransport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jonascheng/speedtest-go/speedtest"
+)
+
+func TestTransport(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	client := resty.New().SetRetryCount(2)
+	httpmock.ActivateNonDefault(client.GetClient())
+	calls := 0
+	httpmock.RegisterResponder("GET", "http://fake.com/latency.txt", func(req *http.Request) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("connection reset")
+		}
+		assert.Equal(t, "value", req.Header.Get("X-Test"))
+		return httpmock.NewStringResponse(200, "test=test"), nil
+	})
+	httpmock.RegisterResponder("POST", "http://fake.com/upload.php", func(req *http.Request) (*http.Response, error) {
+		n, _ := io.Copy(io.Discard, req.Body)
+		assert.Equal(t, int64(5), n)
+		return httpmock.NewStringResponse(200, "size=5"), nil
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "http://fake.com/latency.txt", nil)
+	req.Header.Set("X-Test", "value")
+	resp, err := New(client).Do(req)
+	assert.NoError(t, err, "unexpected error %v", err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "test=test", string(body))
+	assert.Equal(t, 2, calls, "requests without a body should be retried by resty")
+
+	req, _ = http.NewRequest(http.MethodPost, "http://fake.com/upload.php", strings.NewReader("12345"))
+	resp, err = New(client).Do(req)
+	assert.NoError(t, err, "unexpected error %v", err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	assert.Equal(t, client.GetClient(), New(client).Tests())
+}
+
+// errorLogger records the errors logged by resty.
+type errorLogger struct {
+	mu     sync.Mutex
+	errors []string
+}
+
+func (l *errorLogger) Errorf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, fmt.Sprintf(format, v...))
+}
+func (l *errorLogger) Warnf(format string, v ...interface{})  {}
+func (l *errorLogger) Debugf(format string, v ...interface{}) {}
+
+func TestTests(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	logger := &errorLogger{}
+	client := resty.New().SetLogger(logger).SetRetryCount(1)
+	httpmock.ActivateNonDefault(client.GetClient())
+	httpmock.RegisterResponder("GET", "http://fake.com/latency.txt", httpmock.NewStringResponder(200, "test=test"))
+	httpmock.RegisterResponder("GET", `=~^http://fake\.com/random\d+x\d+\.jpg`, httpmock.NewErrorResponder(context.DeadlineExceeded))
+
+	// a failing download is neither retried nor logged by resty
+	s := speedtest.NewServer("http://fake.com/upload.php")
+	err := s.DownloadTest(New(client).Tests(), speedtest.WithDuration(100*time.Millisecond))
+	assert.Error(t, err)
+	assert.LessOrEqual(t, httpmock.GetCallCountInfo()[`GET =~^http://fake\.com/random\d+x\d+\.jpg`], 2, "warm up requests should not be retried")
+	assert.Empty(t, logger.errors)
+
+	// tests given the Transport itself go through resty
+	err = s.DownloadTest(New(client), speedtest.WithDuration(100*time.Millisecond))
+	assert.Error(t, err)
+	assert.NotEmpty(t, logger.errors)
+}
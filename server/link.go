@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Link describes the network a Server emulates between itself and its clients.
+// The zero value emulates nothing.
+type Link struct {
+	// Downlink caps the throughput of random images in bits per second, 0 is unlimited.
+	Downlink int64 `json:"downlink"`
+	// Uplink caps the throughput of uploads in bits per second, 0 is unlimited.
+	Uplink int64 `json:"uplink"`
+	// Aggregate shares the caps among all connections instead of applying them to each one.
+	// As requests on a connection do not overlap, a cap per connection is one per request.
+	Aggregate bool `json:"aggregate"`
+	// Delay is waited before every request is answered.
+	Delay time.Duration `json:"delay"`
+	// Jitter varies Delay uniformly by up to plus or minus Jitter.
+	Jitter time.Duration `json:"jitter"`
+	// ResetRate is the probability, from 0 to 1, that the connection of a request is reset
+	// before the transfer completes.
+	ResetRate float64 `json:"reset_rate"`
+}
+
+// WithLink emulates link on every request.
+func WithLink(link Link) Option {
+	return func(s *Server) {
+		s.link = link
+	}
+}
+
+// delay waits the emulated delay, unless ctx is done before.
+func (l Link) delay(ctx context.Context) {
+	d := l.Delay
+	if l.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * float64(l.Jitter))
+	}
+	if d <= 0 {
+		return
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// reset tells whether the connection of a request is reset.
+func (l Link) reset() bool {
+	return l.ResetRate > 0 && rand.Float64() < l.ResetRate
+}
+
+// resetConn aborts the connection of w, with a TCP reset when possible.
+func resetConn(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	if h, ok := w.(http.Hijacker); ok {
+		if conn, _, err := h.Hijack(); err == nil {
+			if tcp, ok := conn.(*net.TCPConn); ok {
+				_ = tcp.SetLinger(0)
+			}
+			_ = conn.Close()
+			return
+		}
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// shapeChunk is the largest number of bytes passed at once by a limiter, which keeps
+// shaped transfers smooth.
+const shapeChunk = 16 * 1024
+
+// limiterSlack is how far the virtual clock of a limiter may fall behind, so that bytes
+// delayed by timers waking up late are made up for, rather than lost.
+const limiterSlack = 10 * time.Millisecond
+
+// limiter paces bytes to a rate, scheduling them one after the other on a virtual clock.
+type limiter struct {
+	mu sync.Mutex
+	// rate is in bytes per second
+	rate float64
+	next time.Time
+}
+
+// newLimiter returns a limiter of bits per second, or nil when bits is not positive.
+func newLimiter(bits int64) *limiter {
+	if bits <= 0 {
+		return nil
+	}
+	return &limiter{rate: float64(bits) / 8}
+}
+
+// wait blocks until n more bytes fit the rate, or ctx is done.
+func (l *limiter) wait(ctx context.Context, n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if idle := now.Add(-limiterSlack); l.next.Before(idle) {
+		l.next = idle
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	d := l.next.Sub(now)
+	l.mu.Unlock()
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// shapedWriter writes to w at the rate of l.
+type shapedWriter struct {
+	ctx context.Context
+	w   io.Writer
+	l   *limiter
+}
+
+func (s *shapedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > shapeChunk {
+			chunk = chunk[:shapeChunk]
+		}
+		s.l.wait(s.ctx, len(chunk))
+		n, err := s.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// shapedReader reads from r at the rate of l.
+type shapedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *limiter
+}
+
+func (s *shapedReader) Read(p []byte) (int, error) {
+	if len(p) > shapeChunk {
+		p = p[:shapeChunk]
+	}
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.l.wait(s.ctx, n)
+	}
+	return n, err
+}
+
+// downlink returns w shaped by the downlink cap of the link, if any.
+func (s *Server) downlink(r *http.Request, w io.Writer) io.Writer {
+	l := s.down
+	if l == nil {
+		l = newLimiter(s.link.Downlink)
+	}
+	if l == nil {
+		return w
+	}
+	return &shapedWriter{ctx: r.Context(), w: w, l: l}
+}
+
+// uplink returns the body of r shaped by the uplink cap of the link, if any.
+func (s *Server) uplink(r *http.Request) io.Reader {
+	l := s.up
+	if l == nil {
+		l = newLimiter(s.link.Uplink)
+	}
+	if l == nil {
+		return r.Body
+	}
+	return &shapedReader{ctx: r.Context(), r: r.Body, l: l}
+}
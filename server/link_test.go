@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jonascheng/speedtest-go/speedtest"
+)
+
+func TestLinkDelay(t *testing.T) {
+	ts := httptest.NewServer(New(WithLink(Link{Delay: 100 * time.Millisecond, Jitter: 20 * time.Millisecond})))
+	defer ts.Close()
+
+	for i := 0; i < 3; i++ {
+		sTime := time.Now()
+		_, body := get(t, ts.URL+"/latency.txt")
+		elapsed := time.Since(sTime)
+		assert.Equal(t, "test=test", string(body))
+		assert.GreaterOrEqual(t, int64(elapsed), int64(80*time.Millisecond))
+		assert.Less(t, int64(elapsed), int64(500*time.Millisecond))
+	}
+}
+
+func TestLinkDownlink(t *testing.T) {
+	// 4 Mbit/s is 500 kB/s
+	ts := httptest.NewServer(New(WithLink(Link{Downlink: 4000000})))
+	defer ts.Close()
+
+	sTime := time.Now()
+	_, body := get(t, ts.URL+"/random500x500.jpg")
+	elapsed := time.Since(sTime)
+	assert.Equal(t, 2*500*500, len(body))
+	assert.InDelta(t, time.Second.Seconds(), elapsed.Seconds(), 0.2)
+}
+
+func TestLinkUplink(t *testing.T) {
+	ts := httptest.NewServer(New(WithLink(Link{Uplink: 4000000})))
+	defer ts.Close()
+
+	sTime := time.Now()
+	resp, err := http.Post(ts.URL+"/upload.php", "application/octet-stream", strings.NewReader(strings.Repeat("x", 250000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	elapsed := time.Since(sTime)
+	assert.Equal(t, "size=250000", string(body))
+	assert.InDelta(t, 0.5, elapsed.Seconds(), 0.2)
+}
+
+func TestLinkAggregate(t *testing.T) {
+	for _, aggregate := range []bool{false, true} {
+		ts := httptest.NewServer(New(WithLink(Link{Downlink: 4000000, Aggregate: aggregate})))
+
+		sTime := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				get(t, ts.URL+"/random250x250.jpg")
+			}()
+		}
+		wg.Wait()
+		elapsed := time.Since(sTime)
+		ts.Close()
+
+		// two images of 125 kB take 250ms each, in parallel unless they share the cap
+		if aggregate {
+			assert.InDelta(t, 0.5, elapsed.Seconds(), 0.15)
+		} else {
+			assert.InDelta(t, 0.25, elapsed.Seconds(), 0.15)
+		}
+	}
+}
+
+func TestLinkReset(t *testing.T) {
+	ts := httptest.NewServer(New(WithLink(Link{ResetRate: 1})))
+	defer ts.Close()
+
+	for _, p := range []string{"/latency.txt", "/random500x500.jpg"} {
+		resp, err := http.Get(ts.URL + p)
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		assert.Error(t, err, p)
+	}
+
+	resp, err := http.Post(ts.URL+"/upload.php", "application/octet-stream", strings.NewReader(strings.Repeat("x", 250000)))
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	assert.Error(t, err)
+}
+
+func TestLinkMeasured(t *testing.T) {
+	ts := httptest.NewServer(New(WithLink(Link{Downlink: 20000000, Aggregate: true, Delay: 20 * time.Millisecond})))
+	defer ts.Close()
+
+	s := speedtest.NewServer(ts.URL + "/upload.php")
+	err := s.PingTestContext(context.Background(), &http.Client{})
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.GreaterOrEqual(t, int64(s.Latency), int64(10*time.Millisecond))
+
+	err = s.DownloadTestContext(context.Background(), &http.Client{}, speedtest.WithDuration(time.Second))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.InDelta(t, 20.0, s.DLSpeed, 3.0)
+}
+
+func TestLinkUplinkMeasured(t *testing.T) {
+	ts := httptest.NewServer(New(WithLink(Link{Uplink: 40000000})))
+	defer ts.Close()
+
+	// bytes still buffered by the socket of the client are not counted
+	s := speedtest.NewServer(ts.URL + "/upload.php")
+	err := s.UploadTestContext(context.Background(), &http.Client{}, speedtest.WithDuration(time.Second), speedtest.WithUploadStreams(1))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.InDelta(t, 40.0, s.ULSpeed, 6.0)
+}
@@ -0,0 +1,225 @@
+// Package server serves the HTTP endpoints of a legacy speedtest.net server and of a LibreSpeed
+// server, which are the ones the speedtest package requests, so that tests can run against own
+// infrastructure without the OoklaServer image or a PHP installation.
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+)
+
+// randomBlock is the data random images are made of. It is repeated to fill larger images.
+var randomBlock = func() []byte {
+	b := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(b)
+	return b
+}()
+
+var randomImage = regexp.MustCompile(`^random(\d+)x(\d+)\.jpg$`)
+
+// garbage.php of LibreSpeed returns chunks of 1 MiB, 4 unless ckSize says otherwise, up to 1024.
+const (
+	garbageChunk     = 1024 * 1024
+	garbageChunks    = 4
+	garbageChunksMax = 1024
+)
+
+// Server is an http.Handler serving the endpoints of a legacy speedtest.net server under any path:
+//
+//   - random{N}x{N}.jpg returns 2*N*N bytes of random data
+//   - upload.php drains the posted body and replies size={bytes}
+//   - latency.txt replies test=test
+//
+// and those of a LibreSpeed server:
+//
+//   - garbage.php returns ckSize chunks of 1 MiB of random data
+//   - empty.php drains the posted body and replies nothing
+//   - getIP.php replies the address of the client
+//
+// Requests go through the Link given by WithLink.
+type Server struct {
+	maxImageSize int
+	link         Link
+	// down and up are shared by all connections of an aggregate link
+	down *limiter
+	up   *limiter
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithMaxImageSize refuses random images wider or higher than n pixels.
+func WithMaxImageSize(n int) Option {
+	return func(s *Server) {
+		s.maxImageSize = n
+	}
+}
+
+// New returns a Server with opts applied.
+func New(opts ...Option) *Server {
+	s := &Server{
+		maxImageSize: 4000,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.link.Aggregate {
+		s.down = newLimiter(s.link.Downlink)
+		s.up = newLimiter(s.link.Uplink)
+	}
+	return s
+}
+
+// ListenAndServe serves the endpoints of s on addr.
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	var serve func(http.ResponseWriter, *http.Request)
+	switch {
+	case name == "latency.txt":
+		serve = s.latency
+	case name == "upload.php":
+		serve = s.upload
+	case randomImage.MatchString(name):
+		serve = s.download
+	case name == "garbage.php":
+		serve = s.garbage
+	case name == "empty.php":
+		serve = s.empty
+	case name == "getIP.php":
+		serve = s.getIP
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	s.link.delay(r.Context())
+	serve(w, r)
+}
+
+func (s *Server) latency(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "no-cache")
+	if s.link.reset() {
+		resetConn(w)
+		return
+	}
+	_, _ = io.WriteString(w, "test=test")
+}
+
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	n, ok := s.drain(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = io.WriteString(w, "size="+strconv.FormatInt(n, 10))
+}
+
+// empty serves both the uploads and the pings of LibreSpeed.
+func (s *Server) empty(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.drain(w, r); !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	w.WriteHeader(http.StatusOK)
+}
+
+// drain reads the body of r, and tells whether a reply is expected.
+func (s *Server) drain(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	body := s.uplink(r)
+	if s.link.reset() {
+		// drain part of the body, or of a first chunk of it when its length is unknown
+		size := r.ContentLength
+		if size < 0 {
+			size = shapeChunk
+		}
+		_, _ = io.CopyN(io.Discard, body, rand.Int63n(size+1))
+		resetConn(w)
+		return 0, false
+	}
+
+	n, err := io.Copy(io.Discard, body)
+	if err != nil {
+		// the client went away, there is no one to reply to
+		return n, false
+	}
+	return n, true
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	m := randomImage.FindStringSubmatch(path.Base(r.URL.Path))
+	width, err1 := strconv.Atoi(m[1])
+	height, err2 := strconv.Atoi(m[2])
+	if err1 != nil || err2 != nil || width > s.maxImageSize || height > s.maxImageSize {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	s.random(w, r, int64(2*width*height))
+}
+
+func (s *Server) garbage(w http.ResponseWriter, r *http.Request) {
+	chunks := garbageChunks
+	if n, err := strconv.Atoi(r.URL.Query().Get("ckSize")); err == nil && n > 0 {
+		chunks = n
+	}
+	if chunks > garbageChunksMax {
+		chunks = garbageChunksMax
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Description", "File Transfer")
+	w.Header().Set("Content-Disposition", "attachment; filename=random.dat")
+	s.random(w, r, int64(chunks)*garbageChunk)
+}
+
+// random replies size bytes of random data through the downlink.
+func (s *Server) random(w http.ResponseWriter, r *http.Request, size int64) {
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	if s.link.reset() {
+		_, _ = io.CopyN(s.downlink(r, w), &blockReader{}, rand.Int63n(size+1))
+		resetConn(w)
+		return
+	}
+	_, _ = io.CopyN(s.downlink(r, w), &blockReader{}, size)
+}
+
+// getIP replies the address of the client in the format of getIP.php, without ISP information.
+func (s *Server) getIP(w http.ResponseWriter, r *http.Request) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	_ = json.NewEncoder(w).Encode(struct {
+		ProcessedString string `json:"processedString"`
+		RawISPInfo      string `json:"rawIspInfo"`
+	}{ip, ""})
+}
+
+// blockReader repeats randomBlock endlessly.
+type blockReader struct {
+	off int
+}
+
+func (b *blockReader) Read(p []byte) (int, error) {
+	n := copy(p, randomBlock[b.off:])
+	b.off = (b.off + n) % len(randomBlock)
+	return n, nil
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jonascheng/speedtest-go/speedtest"
+)
+
+func get(t *testing.T, url string) (*http.Response, []byte) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestLatency(t *testing.T) {
+	ts := httptest.NewServer(New())
+	defer ts.Close()
+
+	resp, body := get(t, ts.URL+"/speedtest/latency.txt")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "test=test", string(body))
+}
+
+func TestRandomImage(t *testing.T) {
+	ts := httptest.NewServer(New())
+	defer ts.Close()
+
+	resp, body := get(t, ts.URL+"/random350x350.jpg")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/jpeg", resp.Header.Get("Content-Type"))
+	assert.Equal(t, 2*350*350, len(body))
+
+	// larger than the random block
+	_, body = get(t, ts.URL+"/speedtest/random1000x1000.jpg")
+	assert.Equal(t, 2*1000*1000, len(body))
+	assert.NotEqual(t, strings.Repeat("\x00", 100), string(body[:100]))
+}
+
+func TestRandomImageTooLarge(t *testing.T) {
+	ts := httptest.NewServer(New(WithMaxImageSize(500)))
+	defer ts.Close()
+
+	resp, _ := get(t, ts.URL+"/random750x750.jpg")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = get(t, ts.URL+"/random500x500.jpg")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestUpload(t *testing.T) {
+	ts := httptest.NewServer(New())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/speedtest/upload.php", "application/x-www-form-urlencoded", strings.NewReader(strings.Repeat("x", 12345)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "size=12345", string(body))
+}
+
+func TestNotFound(t *testing.T) {
+	ts := httptest.NewServer(New())
+	defer ts.Close()
+
+	for _, p := range []string{"/", "/index.html", "/randomx.jpg", "/random10x10.png"} {
+		resp, _ := get(t, ts.URL+p)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, p)
+	}
+}
+
+func TestSpeedtest(t *testing.T) {
+	ts := httptest.NewServer(New())
+	defer ts.Close()
+
+	s := speedtest.NewServer(ts.URL + "/speedtest/upload.php")
+	// uploads are counted once acknowledged, two of them fit the window
+	res, err := s.Run(&http.Client{},
+		speedtest.WithDuration(200*time.Millisecond),
+		speedtest.WithUploadDuration(500*time.Millisecond),
+		speedtest.WithUploadStreams(2),
+		speedtest.WithLoadedLatencyInterval(0))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Greater(t, int64(res.Ping.Latency), int64(0))
+	assert.Greater(t, res.Download.Speed, 0.0)
+	assert.Greater(t, res.Upload.Speed, 0.0)
+}
+
+func TestGarbage(t *testing.T) {
+	ts := httptest.NewServer(New())
+	defer ts.Close()
+
+	resp, body := get(t, ts.URL+"/backend/garbage.php?ckSize=3")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, 3*garbageChunk, len(body))
+
+	_, body = get(t, ts.URL+"/garbage.php")
+	assert.Equal(t, garbageChunks*garbageChunk, len(body))
+
+	resp, err := http.Head(ts.URL + "/garbage.php?ckSize=100000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, int64(garbageChunksMax*garbageChunk), resp.ContentLength)
+}
+
+func TestEmpty(t *testing.T) {
+	ts := httptest.NewServer(New())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/backend/empty.php", "application/octet-stream", strings.NewReader(strings.Repeat("x", 12345)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, body)
+
+	resp, body = get(t, ts.URL+"/backend/empty.php?r=0.5")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, body)
+}
+
+func TestGetIP(t *testing.T) {
+	ts := httptest.NewServer(New())
+	defer ts.Close()
+
+	resp, body := get(t, ts.URL+"/backend/getIP.php")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"processedString":"127.0.0.1","rawIspInfo":""}`, string(body))
+}
+
+func TestSpeedtestLibreSpeed(t *testing.T) {
+	ts := httptest.NewServer(New())
+	defer ts.Close()
+
+	s := speedtest.NewServer(ts.URL + "/backend/")
+	res, err := s.Run(&http.Client{},
+		speedtest.WithProtocol(speedtest.ProtocolLibreSpeed),
+		speedtest.WithDuration(200*time.Millisecond),
+		speedtest.WithUploadDuration(500*time.Millisecond),
+		speedtest.WithUploadStreams(2),
+		speedtest.WithLoadedLatencyInterval(0))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, ts.URL+"/backend/", res.Download.URL)
+	assert.Greater(t, int64(res.Ping.Latency), int64(0))
+	assert.Greater(t, res.Download.Speed, 0.0)
+	assert.Greater(t, res.Upload.Speed, 0.0)
+}
@@ -2,37 +2,108 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 
+	"github.com/jonascheng/speedtest-go/restytransport"
+	speedtestserver "github.com/jonascheng/speedtest-go/server"
 	"github.com/jonascheng/speedtest-go/speedtest"
 	// A Go (golang) command line and flag parser
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	showList   = kingpin.Flag("list", "Show available speedtest.net servers.").Short('l').Bool()
-	serverIds  = kingpin.Flag("id", "Select server id to speedtest, which id(s) is obtained by option 'list'.").Short('i').Ints()
-	server     = kingpin.Flag("server", "Specify server to speedtest, ex: http://your.speedtest:8080/upload.php").Short('s').String()
-	jsonOutput = kingpin.Flag("json", "Output results in json format").Bool()
+	testCmd    = kingpin.Command("test", "Run a speed test, the default command.").Default()
+	serveCmd   = kingpin.Command("serve", "Serve latency.txt, random images and upload.php, so that this binary can act as the server of tests.")
+	listenAddr = serveCmd.Flag("listen", "Address to serve on.").Default(":8080").String()
+	serveUDP   = serveCmd.Flag("udp-echo", "Also echo datagrams received on the listen address, for udp tests.").Bool()
+	maxImgSize = serveCmd.Flag("max-image-size", "Largest width and height of random images served.").Default("4000").Int()
+	downlink   = serveCmd.Flag("downlink", "Cap the throughput of downloads to the given Mbit/s, 0 is unlimited.").Float64()
+	uplink     = serveCmd.Flag("uplink", "Cap the throughput of uploads to the given Mbit/s, 0 is unlimited.").Float64()
+	aggregate  = serveCmd.Flag("aggregate", "Share --downlink and --uplink among all connections instead of capping each one.").Bool()
+	delay      = serveCmd.Flag("delay", "Delay every reply by the given duration, ex: 20ms.").Duration()
+	jitter     = serveCmd.Flag("jitter", "Vary --delay uniformly by up to plus or minus the given duration.").Duration()
+	resetRate  = serveCmd.Flag("reset-rate", "Probability, from 0 to 1, that a request has its connection reset.").Float64()
 )
 
+var (
+	showList    = kingpin.Flag("list", "Show available speedtest.net servers.").Short('l').Bool()
+	serverIds   = kingpin.Flag("id", "Select server id to speedtest, which id(s) is obtained by option 'list'.").Short('i').Ints()
+	idMatch     = kingpin.Flag("id-match", "How to treat server id(s) missing from the list: fallback tests the nearest server if none matches, strict fails, partial tests the servers found and warns about the others.").Default("fallback").Enum("fallback", "strict", "partial")
+	server      = kingpin.Flag("server", "Specify server to speedtest, ex: http://your.speedtest:8080/upload.php").Short('s').String()
+	jsonOutput  = kingpin.Flag("json", "Output results in json format").Bool()
+	configURL   = kingpin.Flag("config-url", "Fetch user information from the given speedtest-config.php instead of speedtest.net.").String()
+	serversURL  = kingpin.Flag("server-list-url", "Fetch the server list, in XML, JSON or the JSON of LibreSpeed, from the given URL instead of speedtest.net.").String()
+	serverFile  = kingpin.Flag("server-list-file", "Read the server list from the given XML, JSON or LibreSpeed JSON file instead of fetching it.").ExistingFile()
+	lat         = kingpin.Flag("lat", "Sort servers by distance to the given latitude instead of the one determined by speedtest.net, requires --lon.").String()
+	lon         = kingpin.Flag("lon", "Sort servers by distance to the given longitude instead of the one determined by speedtest.net, requires --lat.").String()
+	noOrigin    = kingpin.Flag("no-origin", "Keep the order of the server list instead of sorting it by distance.").Bool()
+	cacheTTL    = kingpin.Flag("cache-ttl", "Reuse user information and server list fetched within the given duration, ex: 1h. Cached copies are also used when fetching fails. 0 disables the cache.").Duration()
+	cacheDir    = kingpin.Flag("cache-dir", "Directory of the cache, speedtest-go in the user cache directory by default.").String()
+	refresh     = kingpin.Flag("refresh", "Fetch user information and server list even when the cache holds a fresh copy.").Bool()
+	countries   = kingpin.Flag("country", "Only use servers of the given country code(s), ex: TW.").Strings()
+	exCountry   = kingpin.Flag("exclude-country", "Skip servers of the given country code(s).").Strings()
+	sponsors    = kingpin.Flag("sponsor", "Only use servers whose sponsor matches the given case insensitive regular expression(s).").Strings()
+	exSponsor   = kingpin.Flag("exclude-sponsor", "Skip servers whose sponsor matches the given case insensitive regular expression(s).").Strings()
+	names       = kingpin.Flag("name", "Only use servers whose name matches the given case insensitive regular expression(s).").Strings()
+	exName      = kingpin.Flag("exclude-name", "Skip servers whose name matches the given case insensitive regular expression(s).").Strings()
+	hosts       = kingpin.Flag("host-suffix", "Only use servers whose host name ends with the given suffix(es), ex: .hinet.net").Strings()
+	exHost      = kingpin.Flag("exclude-host-suffix", "Skip servers whose host name ends with the given suffix(es).").Strings()
+	maxDist     = kingpin.Flag("max-distance", "Only use servers within the given distance in km.").Float64()
+	nearest     = kingpin.Flag("nearest", "Only use the given number of nearest servers.").Int()
+	best        = kingpin.Flag("best", "Test the server with the lowest latency among the nearest ones, unless server id(s) are given.").Short('b').Bool()
+	fallbackN   = kingpin.Flag("fallback-servers", "Number of next nearest servers, or next fastest ones with --best, tried when the selected server fails. Servers given by id are not replaced.").Default("3").Int()
+	candidates  = kingpin.Flag("candidates", "Number of nearest servers pinged to find the best one.").Default("5").Int()
+	verbose     = kingpin.Flag("verbose", "Show details, such as the latency of candidates for the best server.").Short('v').Bool()
+	udp         = kingpin.Flag("udp", "Measure packet loss, reordering, duplication and jitter by sending a stream of datagrams to a UDP echo server, such as serve --udp-echo, at the host of the server by default. Servers of speedtest.net do not echo them.").Bool()
+	udpCount    = kingpin.Flag("udp-count", "Number of datagrams sent by the udp test.").Default("100").Int()
+	udpEchoAddr = kingpin.Flag("udp-echo-addr", "Address of the UDP echo server datagrams are sent to, the host of the server by default, ex: echo.example.com:7").String()
+	protocol    = kingpin.Flag("protocol", "Protocol of tests: http requests images and upload.php, tcp speaks the socket protocol of OoklaServer to the host of the server, librespeed requests garbage.php and empty.php.").Default("http").Enum("http", "tcp", "librespeed")
+	duration    = kingpin.Flag("duration", "Run download and upload tests for a fixed duration each, ex: 10s. The test length of speedtest-config.php, or else a fixed number of requests, is used by default.").Duration()
+	streams     = kingpin.Flag("streams", "Number of parallel streams of download and upload tests. The threads of speedtest-config.php, or else the warm up speed, decide by default.").Int()
+	noCfgTests  = kingpin.Flag("no-config-settings", "Ignore the test length and threads of speedtest-config.php, which download and upload tests use by default.").Bool()
+	sampleInt   = kingpin.Flag("sample-interval", "Interval of throughput samples included in json output, 0 disables sampling.").Default("100ms").Duration()
+	pingCount   = kingpin.Flag("ping-count", "Number of latency samples to take.").Default("3").Int()
+	pingInt     = kingpin.Flag("ping-interval", "Pause between two latency samples, ex: 100ms.").Duration()
+	loadedInt   = kingpin.Flag("loaded-latency-interval", "Interval latency is probed at while download and upload run, 0 disables loaded latency.").Default("200ms").Duration()
+)
+
+// privateConfigTimeout bounds the fetch of speedtest-config.php for private fleets, which
+// may be on networks speedtest.net can not be reached from.
+const privateConfigTimeout = 5 * time.Second
+
+var matchModes = map[string]speedtest.MatchMode{
+	"fallback": speedtest.MatchFallback,
+	"strict":   speedtest.MatchStrict,
+	"partial":  speedtest.MatchPartial,
+}
+
+// fullOutput is the json output. Servers keeps the schema of earlier versions, the
+// measurements of each test set on the server tested, Results holds the complete results.
 type fullOutput struct {
-	UserInfo *speedtest.User   `json:"user_info"`
-	Servers  speedtest.Servers `json:"servers"`
+	UserInfo *speedtest.User         `json:"user_info"`
+	Servers  speedtest.Servers       `json:"servers"`
+	Results  []*speedtest.TestResult `json:"results"`
 }
 
 func main() {
 	kingpin.Version("1.0.0")
-	kingpin.Parse()
+	if kingpin.Parse() == serveCmd.FullCommand() {
+		serve()
+		return
+	}
 
 	// Create a Resty Client
-	client := resty.New()
+	restyClient := resty.New()
 	// Retries are configured per client
-	client.
+	restyClient.
 		// Set retry count to non zero to enable retries
 		SetRetryCount(3).
 		// You can override initial retry wait time.
@@ -41,37 +112,96 @@ func main() {
 		// MaxWaitTime can be overridden as well.
 		// Default is 2 seconds.
 		SetRetryMaxWaitTime(20 * time.Second)
+	// fetches are retried by resty, tests bypass it
+	client := restytransport.New(restyClient)
+	tests := client.Tests()
 
 	var user *speedtest.User
+	var cfg *speedtest.Config
 	var targets speedtest.Servers
+	// fallbacks are tried in order when a target fails on both of its endpoints
+	var fallbacks speedtest.Servers
 	if *server != "" {
 		s := speedtest.NewServer(*server)
 		targets = speedtest.Servers{&s}
 	} else {
-		user, err := speedtest.FetchUserInfo(client)
+		fetchOpts := fetchOptions()
+		var err error
+		// the config is optional to private fleets, it is fetched once without retries
+		var configClient speedtest.Transport = client
+		if *serverFile != "" || *serversURL != "" {
+			configClient = &http.Client{Timeout: privateConfigTimeout}
+		}
+		cfg, err = speedtest.FetchConfig(configClient, fetchOpts...)
+		if err != nil && (*lat != "" || *noOrigin || *serverFile != "" || *serversURL != "") {
+			// servers can be sorted without the location of the user, and private
+			// fleets are listed in order when there is no location at all
+			log.Printf("Warning: %v", err)
+			cfg, err = nil, nil
+		}
 		checkError(err)
-		if !*jsonOutput {
+		if cfg != nil {
+			user = cfg.Client
+			fetchOpts = append(fetchOpts, speedtest.WithConfig(cfg))
+		}
+		if user != nil && !*jsonOutput {
 			showUser(user)
 		}
 
-		serverList, err := speedtest.FetchServerList(client, user)
+		var serverList speedtest.ServerList
+		if *serverFile != "" {
+			serverList, err = speedtest.LoadServerList(*serverFile, user, fetchOpts...)
+		} else {
+			serverList, err = speedtest.FetchServerList(client, user, fetchOpts...)
+		}
+		checkError(err)
+		serverList, err = serverList.Filter(serverFilter())
 		checkError(err)
+		if len(serverList.Servers) == 0 {
+			log.Fatal("no server matches the given filters")
+		}
 		if *showList {
 			showServerList(serverList)
 			return
 		}
 
-		targets, err = serverList.FindServer(*serverIds)
-		checkError(err)
+		if *best && len(*serverIds) == 0 {
+			s, probes, err := serverList.BestServer(tests, speedtest.WithCandidates(*candidates), speedtest.WithProbeProtocol(speedtest.Protocol(*protocol)))
+			if *verbose && !*jsonOutput {
+				showProbes(probes)
+			}
+			checkError(err)
+			targets = speedtest.Servers{s}
+			for _, p := range probes[1:] {
+				if p.Err == nil {
+					fallbacks = append(fallbacks, p.Server)
+				}
+			}
+		} else {
+			targets, err = serverList.FindServerWithMode(*serverIds, matchModes[*idMatch])
+			var notFound *speedtest.ServerNotFoundError
+			if errors.As(err, &notFound) && len(targets) > 0 {
+				log.Printf("Warning: %v", err)
+				err = nil
+			}
+			checkError(err)
+			if len(*serverIds) == 0 {
+				fallbacks = serverList.Servers[1:]
+			}
+		}
+		if *fallbackN >= 0 && len(fallbacks) > *fallbackN {
+			fallbacks = fallbacks[:*fallbackN]
+		}
 	}
 
-	startTest(client, targets, *jsonOutput)
+	results := startTest(tests, targets, fallbacks, *jsonOutput, testOptions(cfg))
 
 	if *jsonOutput {
 		jsonBytes, err := json.MarshalIndent(
 			fullOutput{
 				UserInfo: user,
-				Servers:  targets,
+				Servers:  measuredServers(results),
+				Results:  results,
 			},
 			"",
 			"  ",
@@ -82,71 +212,191 @@ func main() {
 	}
 }
 
-func startTest(client *resty.Client, servers speedtest.Servers, jsonOutput bool) {
-	for _, s := range servers {
-		if !jsonOutput {
-			showServer(s)
-		}
+// measuredServers returns the servers of results with their latency and speeds set.
+func measuredServers(results []*speedtest.TestResult) speedtest.Servers {
+	servers := speedtest.Servers{}
+	for _, res := range results {
+		s := res.Server
+		s.Latency = res.Ping.Latency
+		s.DLSpeed = res.Download.Speed
+		s.ULSpeed = res.Upload.Speed
+		servers = append(servers, &s)
+	}
+	return servers
+}
 
-		err := s.PingTest(client)
+// serve runs the built-in server until it fails.
+func serve() {
+	if *serveUDP {
+		conn, err := net.ListenPacket("udp", *listenAddr)
 		checkError(err)
+		go func() {
+			checkError(speedtest.ServeUDPEcho(conn))
+		}()
+	}
 
-		if jsonOutput {
-			err := s.DownloadTest(client)
-			checkError(err)
+	log.Printf("Serving speedtest endpoints on %s", *listenAddr)
+	link := speedtestserver.Link{
+		Downlink:  int64(*downlink * 1000 * 1000),
+		Uplink:    int64(*uplink * 1000 * 1000),
+		Aggregate: *aggregate,
+		Delay:     *delay,
+		Jitter:    *jitter,
+		ResetRate: *resetRate,
+	}
+	checkError(speedtestserver.New(speedtestserver.WithMaxImageSize(*maxImgSize), speedtestserver.WithLink(link)).ListenAndServe(*listenAddr))
+}
 
-			err = s.UploadTest(client)
-			checkError(err)
+func fetchOptions() []speedtest.FetchOption {
+	opts := []speedtest.FetchOption{}
+	if *configURL != "" {
+		opts = append(opts, speedtest.WithConfigURL(*configURL))
+	}
+	if *serversURL != "" {
+		opts = append(opts, speedtest.WithServerListURL(*serversURL))
+	}
+	if *lat != "" || *lon != "" {
+		la, err := strconv.ParseFloat(*lat, 64)
+		if err != nil {
+			log.Fatalf("invalid --lat %q, both --lat and --lon are required", *lat)
+		}
+		lo, err := strconv.ParseFloat(*lon, 64)
+		if err != nil {
+			log.Fatalf("invalid --lon %q, both --lat and --lon are required", *lon)
+		}
+		opts = append(opts, speedtest.WithOrigin(la, lo))
+	}
+	if *noOrigin {
+		opts = append(opts, speedtest.WithoutOrigin())
+	}
+	if *cacheTTL > 0 {
+		cache, err := speedtest.NewCache(*cacheDir, *cacheTTL)
+		checkError(err)
+		opts = append(opts, speedtest.WithCache(cache))
+	}
+	if *refresh {
+		opts = append(opts, speedtest.WithRefresh())
+	}
+	return opts
+}
 
-			continue
+func serverFilter() speedtest.ServerFilter {
+	return speedtest.ServerFilter{
+		Countries:           *countries,
+		ExcludeCountries:    *exCountry,
+		Sponsors:            *sponsors,
+		ExcludeSponsors:     *exSponsor,
+		Names:               *names,
+		ExcludeNames:        *exName,
+		HostSuffixes:        *hosts,
+		ExcludeHostSuffixes: *exHost,
+		MaxDistance:         *maxDist,
+		Nearest:             *nearest,
+	}
+}
+
+// testOptions returns the test settings of cfg, if any, overridden by flags.
+func testOptions(cfg *speedtest.Config) []speedtest.TestOption {
+	opts := []speedtest.TestOption{}
+	if cfg != nil && !*noCfgTests {
+		opts = append(opts, cfg.TestOptions()...)
+	}
+	opts = append(opts,
+		speedtest.WithProtocol(speedtest.Protocol(*protocol)),
+		speedtest.WithSampleInterval(*sampleInt),
+		speedtest.WithPingCount(*pingCount),
+		speedtest.WithPingInterval(*pingInt),
+		speedtest.WithLoadedLatencyInterval(*loadedInt),
+	)
+	if *duration > 0 {
+		opts = append(opts, speedtest.WithDuration(*duration))
+	}
+	if *streams > 0 {
+		opts = append(opts, speedtest.WithStreams(*streams))
+	}
+	if *udp {
+		udpOpts := []speedtest.UDPOption{speedtest.WithUDPCount(*udpCount)}
+		if *udpEchoAddr != "" {
+			udpOpts = append(udpOpts, speedtest.WithUDPEchoAddr(*udpEchoAddr))
 		}
+		opts = append(opts, speedtest.WithUDP(udpOpts...))
+	}
+	return opts
+}
 
-		showLatencyResult(s)
+func startTest(client speedtest.Transport, servers speedtest.Servers, fallbacks speedtest.Servers, jsonOutput bool, opts []speedtest.TestOption) []*speedtest.TestResult {
+	results := []*speedtest.TestResult{}
+	for _, s := range servers {
+		candidates := append(speedtest.Servers{s}, fallbacks...)
+		if jsonOutput {
+			res, err := candidates.RunWithFailover(client, opts...)
+			if err != nil {
+				log.Printf("Warning: %v", err)
+				continue
+			}
+			results = append(results, res)
+			continue
+		}
 
-		err = testDownload(s, client)
-		checkError(err)
-		err = testUpload(s, client)
-		checkError(err)
+		showServer(s)
+		p := &progress{}
+		res, err := candidates.RunWithFailover(client, append(append([]speedtest.TestOption{}, opts...), speedtest.WithObserver(p.show))...)
+		p.close()
+		if err != nil {
+			log.Printf("Warning: %v", err)
+			continue
+		}
+		results = append(results, res)
 
-		showServerResult(s)
+		showServerResult(res)
 	}
 
-	if !jsonOutput && len(servers) > 1 {
-		showAverageServerResult(servers)
+	if len(results) == 0 {
+		log.Fatal("no server could be tested")
 	}
+	if !jsonOutput && len(results) > 1 {
+		showAverageServerResult(results)
+	}
+	return results
 }
 
-func testDownload(server *speedtest.Server, client *resty.Client) error {
-	quit := make(chan bool)
-	fmt.Printf("Download Test: ")
-	go dots(quit)
-	err := server.DownloadTest(client)
-	quit <- true
-	checkError(err)
-	fmt.Println()
-	return err
+// progress renders the events of a running test, one line per direction.
+type progress struct {
+	open bool
 }
 
-func testUpload(server *speedtest.Server, client *resty.Client) error {
-	quit := make(chan bool)
-	fmt.Printf("Upload Test: ")
-	go dots(quit)
-	err := server.UploadTest(client)
-	quit <- true
-	checkError(err)
-	fmt.Println()
-	return nil
-}
+func (p *progress) show(e speedtest.Event) {
+	label := "Download Test:"
+	if e.Phase == speedtest.PhaseUploadWarmUp || e.Phase == speedtest.PhaseUpload {
+		label = "Upload Test:"
+	}
 
-func dots(quit chan bool) {
-	for {
-		select {
-		case <-quit:
-			return
-		default:
-			time.Sleep(time.Second)
-			fmt.Print(".")
+	switch {
+	case e.Type == speedtest.EventPhaseEnd && e.Err != nil:
+		p.close()
+		fmt.Printf("%s test failed: %v\n", e.Phase, e.Err)
+	case e.Phase == speedtest.PhaseUDP:
+		// shown with the result
+	case e.Phase == speedtest.PhasePing:
+		if e.Type == speedtest.EventPhaseEnd && e.Err == nil {
+			fmt.Println("Latency:", e.Latency)
 		}
+	case e.Type == speedtest.EventPhaseStart && (e.Phase == speedtest.PhaseDownloadWarmUp || e.Phase == speedtest.PhaseUploadWarmUp):
+		p.close()
+		p.open = true
+		fmt.Printf("%s %-40s", label, "warming up")
+	case e.Type == speedtest.EventProgress:
+		fmt.Printf("\r%s %-40s", label, fmt.Sprintf("%.2f Mbit/s (%.1f MB)", e.Speed, float64(e.Bytes)/1000/1000))
+	case e.Type == speedtest.EventPhaseEnd && e.Err == nil:
+		fmt.Printf("\r%s %-40s", label, fmt.Sprintf("%.2f Mbit/s", e.Speed))
+	}
+}
+
+// close ends the line of the current direction.
+func (p *progress) close() {
+	if p.open {
+		fmt.Println()
+		p.open = false
 	}
 }
 
@@ -163,6 +413,19 @@ func showServerList(serverList speedtest.ServerList) {
 	}
 }
 
+func showProbes(probes speedtest.Probes) {
+	fmt.Printf("Best server candidates:\n")
+	for _, p := range probes {
+		fmt.Printf("[%4s] %8.2fkm ", p.Server.ID, p.Server.Distance)
+		if p.Err != nil {
+			fmt.Printf("%12s ", "no answer")
+		} else {
+			fmt.Printf("%12s ", p.Latency)
+		}
+		fmt.Printf(p.Server.Name + " (" + p.Server.Country + ") by " + p.Server.Sponsor + "\n")
+	}
+}
+
 func showServer(s *speedtest.Server) {
 	fmt.Printf(" \n")
 	fmt.Printf("Target Server: [%4s] %8.2fkm\n", s.ID, s.Distance)
@@ -170,31 +433,54 @@ func showServer(s *speedtest.Server) {
 	fmt.Printf("\t> " + s.URL + "\n")
 }
 
-func showLatencyResult(server *speedtest.Server) {
-	fmt.Println("Latency:", server.Latency)
-}
-
 // ShowResult : show testing result
-func showServerResult(server *speedtest.Server) {
+func showServerResult(res *speedtest.TestResult) {
 	fmt.Printf(" \n")
 
-	fmt.Printf("Download: %5.2f Mbit/s\n", server.DLSpeed)
-	fmt.Printf("Upload: %5.2f Mbit/s\n\n", server.ULSpeed)
-	valid := server.CheckResultValid()
+	stats := res.Ping.Stats
+	fmt.Printf("RTT min/avg/median/max: %s/%s/%s/%s\n", stats.Min, stats.Mean, stats.Median, stats.Max)
+	fmt.Printf("RTT stddev: %s, jitter: %s\n", stats.StdDev, stats.Jitter)
+	if u := res.UDP; u != nil {
+		fmt.Printf("UDP: %d/%d received, loss: %.1f%%, out of order: %d, duplicates: %d\n", u.Received, u.Sent, u.Loss, u.OutOfOrder, u.Duplicates)
+		fmt.Printf("UDP RTT min/median/max: %s/%s/%s, jitter: %s\n", u.RTT.Min, u.RTT.Median, u.RTT.Max, u.RTT.Jitter)
+	}
+
+	if len(res.Failovers) > 0 {
+		for _, f := range res.Failovers {
+			fmt.Printf("Failover: [%4s] %s %s test failed: %s\n", f.ServerID, f.URL, f.Phase, f.Err)
+		}
+		fmt.Printf("Tested: [%4s] ping %s, download %s, upload %s\n", res.Server.ID, res.Ping.URL, res.Download.URL, res.Upload.URL)
+	}
+	fmt.Printf("Download: %5.2f Mbit/s\n", res.Download.Speed)
+	fmt.Printf("Upload: %5.2f Mbit/s\n", res.Upload.Speed)
+	showLoadedLatency("Download", res.Download.Latency, res.Ping.Stats)
+	showLoadedLatency("Upload", res.Upload.Latency, res.Ping.Stats)
+	if res.Bufferbloat != "" {
+		fmt.Printf("Bufferbloat grade: %s\n", res.Bufferbloat)
+	}
+	fmt.Println()
+	valid := res.CheckResultValid()
 	if !valid {
 		fmt.Println("Warning: Result seems to be wrong. Please speedtest again.")
 	}
 }
 
-func showAverageServerResult(servers speedtest.Servers) {
+func showLoadedLatency(direction string, loaded speedtest.LatencyStats, idle speedtest.LatencyStats) {
+	if len(loaded.Samples) == 0 {
+		return
+	}
+	fmt.Printf("%s loaded RTT median: %s (+%s), max: %s, jitter: %s\n", direction, loaded.Median, loaded.Median-idle.Median, loaded.Max, loaded.Jitter)
+}
+
+func showAverageServerResult(results []*speedtest.TestResult) {
 	avgDL := 0.0
 	avgUL := 0.0
-	for _, s := range servers {
-		avgDL = avgDL + s.DLSpeed
-		avgUL = avgUL + s.ULSpeed
+	for _, r := range results {
+		avgDL = avgDL + r.Download.Speed
+		avgUL = avgUL + r.Upload.Speed
 	}
-	fmt.Printf("Download Avg: %5.2f Mbit/s\n", avgDL/float64(len(servers)))
-	fmt.Printf("Upload Avg: %5.2f Mbit/s\n", avgUL/float64(len(servers)))
+	fmt.Printf("Download Avg: %5.2f Mbit/s\n", avgDL/float64(len(results)))
+	fmt.Printf("Upload Avg: %5.2f Mbit/s\n", avgUL/float64(len(results)))
 }
 
 func checkError(err error) {
@@ -0,0 +1,74 @@
+package speedtest
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// backend speaks the protocol of a server on behalf of the test engine, which measures
+// the round trips and transfers it performs.
+type backend interface {
+	// endpoint identifies the server in results.
+	endpoint() string
+	// pingSamples returns the round trip times of settings.PingCount requests.
+	pingSamples(ctx context.Context, settings TestSettings, events *emitter) ([]time.Duration, error)
+	// latencyProbe returns a probe of latency on connections separate from those of transfers,
+	// and a func releasing them.
+	latencyProbe() (probeFunc, func())
+	// download returns the warm up and main requests of download tests.
+	download() (warmUp transferFunc, request transferFunc)
+	// upload returns the warm up and main requests of upload tests.
+	upload() (warmUp transferFunc, request transferFunc)
+}
+
+// newBackend returns the backend testing s with settings: the one of LibreSpeed for servers
+// of LibreSpeed, or else the one of settings.Protocol.
+func (s *Server) newBackend(client Transport, settings TestSettings) backend {
+	switch {
+	case s.LibreSpeed != nil || settings.Protocol == ProtocolLibreSpeed:
+		return &libreSpeedBackend{client: client, url: s.URL, endpoints: s.libreSpeedEndpoints()}
+	case settings.Protocol == ProtocolTCP:
+		return &socketBackend{host: s.socketHost()}
+	default:
+		return &ooklaBackend{client: client, url: s.URL}
+	}
+}
+
+// ooklaBackend requests latency.txt, random images and upload.php next to the URL of
+// a legacy speedtest.net server.
+type ooklaBackend struct {
+	client Transport
+	url    string
+}
+
+func (b *ooklaBackend) endpoint() string {
+	return b.url
+}
+
+func (b *ooklaBackend) baseURL() string {
+	return strings.Split(b.url, "/upload.php")[0]
+}
+
+func (b *ooklaBackend) pingSamples(ctx context.Context, settings TestSettings, events *emitter) ([]time.Duration, error) {
+	return httpPingSamples(ctx, b.client, b.baseURL()+"/latency.txt", settings, events)
+}
+
+func (b *ooklaBackend) latencyProbe() (probeFunc, func()) {
+	return httpLatencyProbe(b.client, b.baseURL()+"/latency.txt")
+}
+
+func (b *ooklaBackend) download() (transferFunc, transferFunc) {
+	dlURL := b.baseURL()
+	request := func(ctx context.Context, w int, c *counter) error {
+		return downloadRequest(ctx, b.client, dlURL, w, c)
+	}
+	return request, request
+}
+
+func (b *ooklaBackend) upload() (transferFunc, transferFunc) {
+	request := func(ctx context.Context, w int, c *counter) error {
+		return uploadRequest(ctx, b.client, b.url, w, c)
+	}
+	return request, request
+}
@@ -0,0 +1,76 @@
+package speedtest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Cache keeps copies of the documents fetched from speedtest.net on disk.
+type Cache struct {
+	// Dir is the directory copies are stored in.
+	Dir string
+	// TTL is how long a copy is used instead of fetching the document again.
+	// Older copies are only used when fetching fails.
+	TTL time.Duration
+}
+
+// NewCache returns a Cache storing copies in dir for ttl. An empty dir stands for
+// speedtest-go in the user cache directory, such as $XDG_CACHE_HOME on Linux.
+func NewCache(dir string, ttl time.Duration) (*Cache, error) {
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(base, "speedtest-go")
+	}
+	return &Cache{Dir: dir, TTL: ttl}, nil
+}
+
+// path returns the file the copy of the document at url is stored in.
+func (c *Cache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:8]))
+}
+
+// load returns the copy of the document at url, and whether it is younger than the TTL.
+func (c *Cache) load(url string) ([]byte, bool, error) {
+	p := c.path(url)
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, time.Since(info.ModTime()) < c.TTL, nil
+}
+
+// store replaces the copy of the document at url with data.
+func (c *Cache) store(url string, data []byte) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(c.Dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), c.path(url)); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package speedtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCache(t *testing.T) {
+	c, err := NewCache("/tmp/speedtest", time.Minute)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "/tmp/speedtest", c.Dir)
+	assert.Equal(t, time.Minute, c.TTL)
+
+	base, err := os.UserCacheDir()
+	if err != nil {
+		t.Skip("no user cache directory")
+	}
+	c, err = NewCache("", time.Minute)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, filepath.Join(base, "speedtest-go"), c.Dir)
+}
+
+func TestCacheStoreAndLoad(t *testing.T) {
+	c := &Cache{Dir: filepath.Join(t.TempDir(), "cache"), TTL: time.Minute}
+
+	_, _, err := c.load("http://fake.com/a")
+	assert.Error(t, err, "nothing is cached yet")
+
+	assert.NoError(t, c.store("http://fake.com/a", []byte("a")))
+	assert.NoError(t, c.store("http://fake.com/b", []byte("b")))
+	assert.NoError(t, c.store("http://fake.com/a", []byte("aa")))
+
+	data, fresh, err := c.load("http://fake.com/a")
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.True(t, fresh)
+	assert.Equal(t, "aa", string(data))
+
+	old := time.Now().Add(-2 * time.Minute)
+	assert.NoError(t, os.Chtimes(c.path("http://fake.com/b"), old, old))
+	data, fresh, err = c.load("http://fake.com/b")
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.False(t, fresh)
+	assert.Equal(t, "b", string(data))
+
+	entries, err := os.ReadDir(c.Dir)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 2, len(entries), "temporary files should be renamed")
+}
@@ -0,0 +1,184 @@
+package speedtest
+
+import (
+	"context"
+	"net/http"
+)
+
+// Client fetches user information and server lists, and tests servers, over a Transport.
+// It holds the fetch, selection and test settings of its calls, so that callers need not
+// repeat options. Unlike PingTest, DownloadTest and UploadTest of Server, its
+// methods return measurements instead of storing them in the Server.
+type Client struct {
+	transport  Transport
+	fetchOpts  []FetchOption
+	selectOpts []SelectOption
+	testOpts   []TestOption
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// WithTransport sends requests through t, such as an *http.Client.
+func WithTransport(t Transport) ClientOption {
+	return func(c *Client) {
+		c.transport = t
+	}
+}
+
+// WithRoundTripper sends requests through an http.Client using rt.
+func WithRoundTripper(rt http.RoundTripper) ClientOption {
+	return func(c *Client) {
+		c.transport = &http.Client{Transport: rt}
+	}
+}
+
+// WithFetchOptions fetches user information and server lists with opts, such as the
+// endpoints of WithConfigURL and WithServerListURL.
+func WithFetchOptions(opts ...FetchOption) ClientOption {
+	return func(c *Client) {
+		c.fetchOpts = append(c.fetchOpts, opts...)
+	}
+}
+
+// WithSelectOptions selects the best server with opts.
+func WithSelectOptions(opts ...SelectOption) ClientOption {
+	return func(c *Client) {
+		c.selectOpts = append(c.selectOpts, opts...)
+	}
+}
+
+// WithTestOptions tests servers with opts. Options given to a call are applied after them.
+func WithTestOptions(opts ...TestOption) ClientOption {
+	return func(c *Client) {
+		c.testOpts = append(c.testOpts, opts...)
+	}
+}
+
+// NewClient returns a Client with opts applied, sending requests through an http.Client
+// of its own by default.
+func NewClient(opts ...ClientOption) *Client {
+	c := &Client{
+		transport: newHTTPClient(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// FetchConfig retrieves the client configuration.
+func (c *Client) FetchConfig() (*Config, error) {
+	return c.FetchConfigContext(context.Background())
+}
+
+// FetchConfigContext retrieves the client configuration, observing the given context.
+func (c *Client) FetchConfigContext(ctx context.Context) (*Config, error) {
+	return fetchConfig(ctx, c.transport, c.fetchOpts...)
+}
+
+// FetchUserInfo returns information about the caller.
+func (c *Client) FetchUserInfo() (*User, error) {
+	return c.FetchUserInfoContext(context.Background())
+}
+
+// FetchUserInfoContext returns information about the caller, observing the given context.
+func (c *Client) FetchUserInfoContext(ctx context.Context) (*User, error) {
+	cfg, err := c.FetchConfigContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Client, nil
+}
+
+// FetchServerList retrieves a list of available servers, sorted by distance to user.
+// The user may be nil when an origin is given by the fetch options.
+func (c *Client) FetchServerList(user *User) (ServerList, error) {
+	return c.FetchServerListContext(context.Background(), user)
+}
+
+// FetchServerListContext retrieves a list of available servers, observing the given context.
+func (c *Client) FetchServerListContext(ctx context.Context, user *User) (ServerList, error) {
+	return fetchServerList(ctx, c.transport, user, c.fetchOpts...)
+}
+
+// BestServer pings the nearest servers of l concurrently and returns the one with the lowest
+// latency, along with the probes of all candidates.
+func (c *Client) BestServer(l *ServerList) (*Server, Probes, error) {
+	return c.BestServerContext(context.Background(), l)
+}
+
+// BestServerContext is BestServer observing the given context. Candidates are probed over
+// the protocol of the test options, unless the select options give one.
+func (c *Client) BestServerContext(ctx context.Context, l *ServerList) (*Server, Probes, error) {
+	settings := newSelectSettings(c.selectOpts...)
+	if settings.Protocol == "" {
+		settings.Protocol = c.settings(nil).Protocol
+	}
+	return l.bestServer(ctx, c.transport, settings)
+}
+
+// settings returns the test settings of c overridden by opts.
+func (c *Client) settings(opts []TestOption) TestSettings {
+	return newTestSettings(append(append([]TestOption{}, c.testOpts...), opts...)...)
+}
+
+// PingTest measures the latency of s.
+func (c *Client) PingTest(s *Server, opts ...TestOption) (PingResult, error) {
+	return c.PingTestContext(context.Background(), s, opts...)
+}
+
+// PingTestContext measures the latency of s, observing the given context.
+func (c *Client) PingTestContext(ctx context.Context, s *Server, opts ...TestOption) (PingResult, error) {
+	settings := c.settings(opts)
+	return s.ping(ctx, c.transport, settings, newEmitter(settings.Observer, s))
+}
+
+// DownloadTest measures the download speed of s. The latency of ping, the result of PingTest,
+// is left out of the time of fixed workloads, as Run does.
+func (c *Client) DownloadTest(s *Server, ping PingResult, opts ...TestOption) (TransferResult, error) {
+	return c.DownloadTestContext(context.Background(), s, ping, opts...)
+}
+
+// DownloadTestContext measures the download speed of s, observing the given context.
+func (c *Client) DownloadTestContext(ctx context.Context, s *Server, ping PingResult, opts ...TestOption) (TransferResult, error) {
+	settings := c.settings(opts)
+	return s.download(ctx, c.transport, settings, newEmitter(settings.Observer, s), ping.Latency)
+}
+
+// UploadTest measures the upload speed of s. The latency of ping, the result of PingTest,
+// is left out of the time of fixed workloads, as Run does.
+func (c *Client) UploadTest(s *Server, ping PingResult, opts ...TestOption) (TransferResult, error) {
+	return c.UploadTestContext(context.Background(), s, ping, opts...)
+}
+
+// UploadTestContext measures the upload speed of s, observing the given context.
+func (c *Client) UploadTestContext(ctx context.Context, s *Server, ping PingResult, opts ...TestOption) (TransferResult, error) {
+	settings := c.settings(opts)
+	return s.upload(ctx, c.transport, settings, newEmitter(settings.Observer, s), ping.Latency)
+}
+
+// Run executes ping, download and upload tests against s and returns their result, see Server.Run.
+func (c *Client) Run(s *Server, opts ...TestOption) (*TestResult, error) {
+	return c.RunContext(context.Background(), s, opts...)
+}
+
+// RunContext executes ping, download and upload tests against s, observing the given context.
+func (c *Client) RunContext(ctx context.Context, s *Server, opts ...TestOption) (*TestResult, error) {
+	res, err := s.run(ctx, c.transport, c.settings(opts))
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// RunWithFailover runs the tests of Run against the first of svrs, moving on to the next one
+// when a phase fails, see Servers.RunWithFailover.
+func (c *Client) RunWithFailover(svrs Servers, opts ...TestOption) (*TestResult, error) {
+	return c.RunWithFailoverContext(context.Background(), svrs, opts...)
+}
+
+// RunWithFailoverContext is RunWithFailover observing the given context.
+func (c *Client) RunWithFailoverContext(ctx context.Context, svrs Servers, opts ...TestOption) (*TestResult, error) {
+	return svrs.runWithFailover(ctx, c.transport, c.settings(opts))
+}
@@ -0,0 +1,153 @@
+package speedtest
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingRoundTripper counts requests before passing them to httpmock.
+type countingRoundTripper struct {
+	n int32
+}
+
+func (rt *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&rt.n, 1)
+	return httpmock.DefaultTransport.RoundTrip(req)
+}
+
+func TestNewClient(t *testing.T) {
+	c := NewClient()
+	hc, ok := c.transport.(*http.Client)
+	if assert.True(t, ok) {
+		assert.NotEqual(t, http.DefaultTransport, hc.Transport, "connections should not be shared with the default transport")
+	}
+}
+
+func TestClientFetch(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	hc := &http.Client{}
+	httpmock.ActivateNonDefault(hc)
+	httpmock.RegisterResponder("GET", "http://fake.com/speedtest-config.php", fakeResponder(200, fakeConfigResponse, "application/xml"))
+	httpmock.RegisterResponder("GET", "http://fake.com/servers.xml", fakeResponder(200, `<settings><servers>
+	<server url="http://far.com/upload.php" lat="35.68" lon="139.69" name="Tokyo" id="1" host="far.com"/>
+	<server url="http://near.com/upload.php" lat="25.05" lon="121.53" name="Taipei" id="4" host="near.com"/>
+	</servers></settings>`, "application/xml"))
+
+	c := NewClient(
+		WithTransport(hc),
+		WithFetchOptions(WithConfigURL("http://fake.com/speedtest-config.php"), WithServerListURL("http://fake.com/servers.xml")),
+	)
+
+	user, err := c.FetchUserInfo()
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "211.72.129.103", user.IP)
+
+	cfg, err := c.FetchConfig()
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 4, cfg.ServerConfig.ThreadCount)
+
+	list, err := c.FetchServerList(user)
+	assert.NoError(t, err, "unexpected error %v", err)
+	if assert.Equal(t, 2, len(list.Servers)) {
+		assert.Equal(t, "4", list.Servers[0].ID)
+	}
+}
+
+func TestClientRun(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	hc := newFakeServer()
+
+	c := NewClient(WithTransport(hc), WithTestOptions(WithDuration(100*time.Millisecond), withoutLoadedLatency, WithPingCount(2)))
+	server := NewServer("http://fake.com/upload.php")
+
+	res, err := c.Run(&server, WithPingCount(4))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 4, len(res.Ping.Stats.Samples), "options of the call should override those of the client")
+	assert.Equal(t, 100*time.Millisecond, res.Settings.DownloadDuration)
+	assert.Greater(t, res.Download.Speed, 0.0)
+	assert.Greater(t, res.Upload.Speed, 0.0)
+	assert.Equal(t, 0.0, server.DLSpeed, "the server should be left untouched")
+
+	ping, err := c.PingTest(&server)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 2, len(ping.Stats.Samples))
+
+	dl, err := c.DownloadTest(&server, ping)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Greater(t, dl.Speed, 0.0)
+
+	ul, err := c.UploadTest(&server, ping)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Greater(t, ul.Speed, 0.0)
+	assert.Equal(t, time.Duration(0), server.Latency)
+}
+
+func TestClientDownloadTestLatency(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	hc := &http.Client{}
+	httpmock.ActivateNonDefault(hc)
+	httpmock.RegisterResponder("GET", `=~^http://slow\.com/random\d+x\d+\.jpg`, func(req *http.Request) (*http.Response, error) {
+		time.Sleep(40 * time.Millisecond)
+		return httpmock.NewStringResponse(200, strings.Repeat("x", 1000)), nil
+	})
+
+	c := NewClient(WithTransport(hc))
+	server := NewServer("http://slow.com/upload.php")
+
+	withoutPing, err := c.DownloadTest(&server, PingResult{})
+	assert.NoError(t, err, "unexpected error %v", err)
+	withPing, err := c.DownloadTest(&server, PingResult{Latency: 20 * time.Millisecond})
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Greater(t, withPing.Speed, withoutPing.Speed*1.5, "the latency of the ping result should be left out")
+}
+
+func TestClientRunWithFailover(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	hc := newFakeServer()
+	httpmock.RegisterResponder("GET", "http://broken.com/latency.txt", fakeResponder(500, "", "text/plain"))
+
+	c := NewClient(WithTransport(hc), WithTestOptions(WithDuration(100*time.Millisecond), withoutLoadedLatency))
+	broken := NewServer("http://broken.com/upload.php")
+	fake := NewServer("http://fake.com/upload.php")
+
+	res, err := c.RunWithFailover(Servers{&broken, &fake})
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "http://fake.com/upload.php", res.Ping.URL)
+	assert.Equal(t, 1, len(res.Failovers))
+}
+
+func TestClientBestServer(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	hc := newFakeServer()
+	httpmock.RegisterResponder("GET", "http://slow.com/latency.txt", delayedResponder(100*time.Millisecond))
+
+	c := NewClient(WithTransport(hc), WithSelectOptions(WithProbePingCount(1)))
+	slow := NewServer("http://slow.com/upload.php")
+	fake := NewServer("http://fake.com/upload.php")
+
+	best, probes, err := c.BestServer(&ServerList{Servers: []*Server{&slow, &fake}})
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, &fake, best)
+	assert.Equal(t, 2, len(probes))
+}
+
+func TestClientWithRoundTripper(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	httpmock.Activate()
+	httpmock.RegisterResponder("GET", "http://fake.com/latency.txt", fakeResponder(200, `test=test`, "text/plain"))
+
+	rt := &countingRoundTripper{}
+	c := NewClient(WithRoundTripper(rt))
+	server := NewServer("http://fake.com/upload.php")
+
+	_, err := c.PingTest(&server, WithPingCount(3))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&rt.n))
+}
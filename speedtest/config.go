@@ -0,0 +1,128 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the client configuration handed out by speedtest-config.php.
+type Config struct {
+	Client       *User          `xml:"client" json:"client"`
+	ServerConfig ServerConfig   `xml:"server-config" json:"server_config"`
+	Download     DownloadConfig `xml:"download" json:"download"`
+	Upload       UploadConfig   `xml:"upload" json:"upload"`
+	Latency      LatencyConfig  `xml:"latency" json:"latency"`
+}
+
+// ServerConfig holds the server related settings of a Config.
+type ServerConfig struct {
+	ThreadCount int `xml:"threadcount,attr" json:"thread_count"`
+	// IgnoreIDs is a comma separated list of servers clients should not use.
+	IgnoreIDs         string `xml:"ignoreids,attr" json:"ignore_ids"`
+	NotOnMap          string `xml:"notonmap,attr" json:"not_on_map"`
+	ForcePingID       string `xml:"forcepingid,attr" json:"force_ping_id"`
+	PreferredServerID string `xml:"preferredserverid,attr" json:"preferred_server_id"`
+}
+
+// DownloadConfig holds the download settings of a Config.
+type DownloadConfig struct {
+	// TestLength is the duration of the test in seconds.
+	TestLength    int    `xml:"testlength,attr" json:"test_length"`
+	InitialTest   string `xml:"initialtest,attr" json:"initial_test"`
+	MinTestSize   string `xml:"mintestsize,attr" json:"min_test_size"`
+	ThreadsPerURL int    `xml:"threadsperurl,attr" json:"threads_per_url"`
+}
+
+// UploadConfig holds the upload settings of a Config.
+type UploadConfig struct {
+	// TestLength is the duration of the test in seconds.
+	TestLength    int    `xml:"testlength,attr" json:"test_length"`
+	Ratio         int    `xml:"ratio,attr" json:"ratio"`
+	InitialTest   string `xml:"initialtest,attr" json:"initial_test"`
+	MinTestSize   string `xml:"mintestsize,attr" json:"min_test_size"`
+	Threads       int    `xml:"threads,attr" json:"threads"`
+	MaxChunkSize  string `xml:"maxchunksize,attr" json:"max_chunk_size"`
+	MaxChunkCount int    `xml:"maxchunkcount,attr" json:"max_chunk_count"`
+	ThreadsPerURL int    `xml:"threadsperurl,attr" json:"threads_per_url"`
+}
+
+// LatencyConfig holds the latency settings of a Config.
+type LatencyConfig struct {
+	// TestLength is the duration of the test in seconds.
+	TestLength int `xml:"testlength,attr" json:"test_length"`
+	// WaitTime is the pause between two requests in milliseconds.
+	WaitTime int `xml:"waittime,attr" json:"wait_time"`
+	// Timeout is the timeout of a request in seconds.
+	Timeout int `xml:"timeout,attr" json:"timeout"`
+}
+
+// FetchConfig retrieves the client configuration from speedtest.net
+func FetchConfig(client Transport, opts ...FetchOption) (*Config, error) {
+	return FetchConfigContext(context.Background(), client, opts...)
+}
+
+// FetchConfigContext retrieves the client configuration from speedtest.net, observing the given context.
+func FetchConfigContext(ctx context.Context, client Transport, opts ...FetchOption) (*Config, error) {
+	return fetchConfig(ctx, client, opts...)
+}
+
+func fetchConfig(ctx context.Context, client Transport, opts ...FetchOption) (*Config, error) {
+	var cfg Config
+
+	settings := newFetchSettings(opts...)
+	err := fetch(ctx, client, settings.ConfigURL, "fetching user information", settings, func(data []byte) error {
+		cfg = Config{}
+		if err := xml.Unmarshal(data, &cfg); err != nil {
+			return err
+		}
+		if cfg.Client == nil {
+			return fmt.Errorf("failed to fetch user information from %v", settings.ConfigURL)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// IgnoredIDs returns the IDs of servers clients should not use.
+func (c *ServerConfig) IgnoredIDs() []int {
+	ids := []int{}
+	for _, f := range strings.Split(c.IgnoreIDs, ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(f)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// TestOptions returns the test settings of the configuration, mapped the way speedtest-cli
+// (github.com/sivel/speedtest-cli, Speedtest.get_config) maps them: tests last their test
+// length, download runs threadcount*2 streams and upload runs upload threads streams.
+//
+// Upload.Ratio and Upload.MaxChunkCount are ignored. speedtest-cli uses them to pick the
+// sizes and the number of upload requests, while the tests of this package last their test
+// length with requests of their own sizes. Upload.MaxChunkSize and the ThreadsPerURL of both
+// directions are ignored likewise.
+func (c *Config) TestOptions() []TestOption {
+	opts := []TestOption{}
+	if c.Download.TestLength > 0 {
+		opts = append(opts, WithDownloadDuration(time.Duration(c.Download.TestLength)*time.Second))
+	}
+	if c.Upload.TestLength > 0 {
+		opts = append(opts, WithUploadDuration(time.Duration(c.Upload.TestLength)*time.Second))
+	}
+	if c.ServerConfig.ThreadCount > 0 {
+		opts = append(opts, WithDownloadStreams(c.ServerConfig.ThreadCount*2))
+	}
+	if c.Upload.Threads > 0 {
+		opts = append(opts, WithUploadStreams(c.Upload.Threads))
+	}
+	return opts
+}
@@ -0,0 +1,103 @@
+package speedtest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const fakeConfigResponse = `<settings>
+	<client ip="211.72.129.103" lat="25.0504" lon="121.5324" isp="Chunghwa Telecom" isprating="3.7" rating="0" ispdlavg="0" ispulavg="0" loggedin="0" country="TW"/>
+	<server-config threadcount="4" ignoreids="2, 3,,x" notonmap="" forcepingid="" preferredserverid="1"/>
+	<licensekey>f7a45ced624d3a70-1df5b7cd427370f7-b91ee21d6cb22d7b</licensekey>
+	<times dl1="5000000" dl2="35000000" dl3="800000000" ul1="1000000" ul2="8000000" ul3="35000000"/>
+	<download testlength="10" initialtest="250K" mintestsize="250K" threadsperurl="4"/>
+	<upload testlength="8" ratio="5" initialtest="0" mintestsize="32K" threads="2" maxchunksize="512K" maxchunkcount="50" threadsperurl="4"/>
+	<latency testlength="10" waittime="50" timeout="20"/>
+	</settings>`
+
+func TestFetchConfig(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", speedTestConfigUrl, fakeResponder(200, fakeConfigResponse, "application/xml"))
+
+	cfg, err := FetchConfig(client)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "211.72.129.103", cfg.Client.IP)
+	assert.Equal(t, "TW", cfg.Client.Country)
+	assert.Equal(t, 4, cfg.ServerConfig.ThreadCount)
+	assert.Equal(t, "1", cfg.ServerConfig.PreferredServerID)
+	assert.Equal(t, []int{2, 3}, cfg.ServerConfig.IgnoredIDs())
+	assert.Equal(t, DownloadConfig{TestLength: 10, InitialTest: "250K", MinTestSize: "250K", ThreadsPerURL: 4}, cfg.Download)
+	assert.Equal(t, UploadConfig{TestLength: 8, Ratio: 5, InitialTest: "0", MinTestSize: "32K", Threads: 2, MaxChunkSize: "512K", MaxChunkCount: 50, ThreadsPerURL: 4}, cfg.Upload)
+	assert.Equal(t, LatencyConfig{TestLength: 10, WaitTime: 50, Timeout: 20}, cfg.Latency)
+}
+
+func TestFetchConfigWithEmptyResponse(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", speedTestConfigUrl, fakeResponder(200, `<settings></settings>`, "application/xml"))
+
+	cfg, err := FetchConfig(client)
+	assert.Error(t, err, "should expect error")
+	assert.Nil(t, cfg)
+}
+
+func TestConfigTestOptions(t *testing.T) {
+	// speedtest-cli (Speedtest.get_config) runs int(server_config['threadcount']) * 2
+	// download threads and int(upload['threads']) upload threads.
+	cfg := &Config{
+		ServerConfig: ServerConfig{ThreadCount: 4},
+		Download:     DownloadConfig{TestLength: 10},
+		Upload:       UploadConfig{TestLength: 8, Threads: 2},
+	}
+	s := newTestSettings(cfg.TestOptions()...)
+	assert.Equal(t, 10*time.Second, s.DownloadDuration)
+	assert.Equal(t, 8*time.Second, s.UploadDuration)
+	assert.Equal(t, 8, s.DownloadStreams)
+	assert.Equal(t, 2, s.UploadStreams)
+
+	chunked := *cfg
+	chunked.Upload.Ratio = 5
+	chunked.Upload.MaxChunkSize = "512K"
+	chunked.Upload.MaxChunkCount = 50
+	chunked.Download.ThreadsPerURL = 4
+	assert.Equal(t, s, newTestSettings(chunked.TestOptions()...), "ratio and chunk settings should be ignored")
+
+	s = newTestSettings((&Config{}).TestOptions()...)
+	assert.Equal(t, newTestSettings(), s, "an empty config should keep the defaults")
+
+	s = newTestSettings(append(cfg.TestOptions(), WithDuration(time.Second))...)
+	assert.Equal(t, time.Second, s.DownloadDuration, "later options should override the config")
+}
+
+func TestFetchServerListWithConfig(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", speedTestServersUrl, fakeResponder(200, `<settings>
+	<servers>
+	<server url="http://a.com/upload.php" lat="25.05" lon="121.53" id="1"/>
+	<server url="http://b.com/upload.php" lat="25.05" lon="121.53" id="2"/>
+	<server url="http://c.com/upload.php" lat="25.05" lon="121.53" id="3"/>
+	</servers>
+	</settings>`, "application/xml"))
+
+	cfg := &Config{ServerConfig: ServerConfig{IgnoreIDs: "2,3"}}
+	list, err := FetchServerList(client, nil, WithConfig(cfg))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 1, len(list.Servers))
+	assert.Equal(t, "1", list.Servers[0].ID)
+
+	list, err = FetchServerList(client, nil)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 3, len(list.Servers))
+}
@@ -0,0 +1,174 @@
+package speedtest
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Phase identifies a stage of a speed test.
+type Phase string
+
+// Phases reported by tests
+const (
+	PhasePing           Phase = "ping"
+	PhaseUDP            Phase = "udp"
+	PhaseDownloadWarmUp Phase = "download_warm_up"
+	PhaseDownload       Phase = "download"
+	PhaseUploadWarmUp   Phase = "upload_warm_up"
+	PhaseUpload         Phase = "upload"
+)
+
+// EventType identifies the kind of an Event.
+type EventType int
+
+// Types of events reported to an Observer
+const (
+	// EventPhaseStart is reported when a phase starts.
+	EventPhaseStart EventType = iota
+	// EventPhaseEnd is reported when a phase ends, with its result or error.
+	EventPhaseEnd
+	// EventRequestDone is reported when a single request of a phase completes.
+	EventRequestDone
+	// EventProgress is reported every sample interval while data is transferred.
+	EventProgress
+)
+
+// String representation of EventType
+func (t EventType) String() string {
+	switch t {
+	case EventPhaseStart:
+		return "phase_start"
+	case EventPhaseEnd:
+		return "phase_end"
+	case EventRequestDone:
+		return "request_done"
+	case EventProgress:
+		return "progress"
+	}
+	return "unknown"
+}
+
+// Event reports the progress of a running test.
+type Event struct {
+	Type   EventType
+	Phase  Phase
+	Server *Server
+	Time   time.Time
+	// Elapsed is the time since the start of the phase.
+	Elapsed time.Duration
+	// Bytes is the number of bytes moved by the request for EventRequestDone,
+	// and by the phase so far for other events.
+	Bytes int64
+	// Speed is the instantaneous throughput in Mbps for EventProgress,
+	// and the speed measured by the phase for EventPhaseEnd.
+	Speed float64
+	// Latency is the round trip time of a ping request for EventRequestDone,
+	// and the latency measured by the ping phase for EventPhaseEnd.
+	Latency time.Duration
+	// Err is set when the request or phase failed.
+	Err error
+}
+
+// Observer receives the events of a running test. The events of one test
+// are delivered one at a time, in order, and the test waits for the observer
+// to return.
+type Observer func(Event)
+
+// ChannelObserver returns an Observer sending events to ch. Events are dropped
+// rather than stalling the test when ch is not ready to receive.
+func ChannelObserver(ch chan<- Event) Observer {
+	return func(e Event) {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// emitter delivers the events of one test to an observer.
+type emitter struct {
+	observer Observer
+	server   *Server
+	mu       sync.Mutex
+	phase    Phase
+	start    time.Time
+	bytes    int64
+	// ended is set once the current phase ended, after which no progress is reported
+	ended bool
+}
+
+func newEmitter(observer Observer, server *Server) *emitter {
+	return &emitter{observer: observer, server: server}
+}
+
+// emit delivers e with the current phase and timing filled in.
+func (em *emitter) emit(e Event) {
+	if em.observer == nil {
+		return
+	}
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	em.deliver(e)
+}
+
+func (em *emitter) deliver(e Event) {
+	e.Phase = em.phase
+	e.Server = em.server
+	e.Time = time.Now()
+	e.Elapsed = e.Time.Sub(em.start)
+	em.observer(e)
+}
+
+// startPhase makes p the current phase and reports its start.
+// Bytes of progress events are counted from base.
+func (em *emitter) startPhase(p Phase, base int64) {
+	if em.observer == nil {
+		return
+	}
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	em.phase = p
+	em.start = time.Now()
+	em.bytes = base
+	em.ended = false
+	em.deliver(Event{Type: EventPhaseStart})
+}
+
+// endPhase reports the end of the current phase.
+func (em *emitter) endPhase(e Event) {
+	if em.observer == nil {
+		return
+	}
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	em.ended = true
+	e.Type = EventPhaseEnd
+	em.deliver(e)
+}
+
+// progress reports a throughput sample of the current phase.
+func (em *emitter) progress(s Sample) {
+	if em.observer == nil {
+		return
+	}
+	em.mu.Lock()
+	defer em.mu.Unlock()
+	if em.ended {
+		return
+	}
+	em.deliver(Event{Type: EventProgress, Bytes: s.Bytes - em.bytes, Speed: s.Speed})
+}
+
+// observe wraps f to report every completed request.
+func (em *emitter) observe(f transferFunc) transferFunc {
+	if em.observer == nil {
+		return f
+	}
+	return func(ctx context.Context, w int, c *counter) error {
+		rc := &counter{parent: c}
+		err := f(ctx, w, rc)
+		em.emit(Event{Type: EventRequestDone, Bytes: rc.Load(), Err: err})
+		return err
+	}
+}
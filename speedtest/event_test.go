@@ -0,0 +1,142 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder collects the events reported to its observer.
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) observe(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// filter returns the events of the given type and phase.
+func (r *recorder) filter(t EventType, p Phase) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := []Event{}
+	for _, e := range r.events {
+		if e.Type == t && e.Phase == p {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func TestDownloadTestEvents(t *testing.T) {
+	server := Server{
+		URL: "http://fake.com/upload.php",
+	}
+	rec := &recorder{}
+
+	err := server.downloadTestContext(
+		context.Background(),
+		mockTransport(mockTransfer(100*time.Millisecond), mockStreamingRequest),
+		newTestSettings(WithDuration(300*time.Millisecond), WithObserver(rec.observe), withoutLoadedLatency),
+	)
+	assert.NoError(t, err, "unexpected error %v", err)
+
+	assert.Equal(t, EventPhaseStart, rec.events[0].Type)
+	assert.Equal(t, PhaseDownloadWarmUp, rec.events[0].Phase)
+	assert.Equal(t, &server, rec.events[0].Server)
+	assert.Equal(t, EventPhaseEnd, rec.events[len(rec.events)-1].Type)
+	assert.Equal(t, PhaseDownload, rec.events[len(rec.events)-1].Phase)
+	assert.Equal(t, server.DLSpeed, rec.events[len(rec.events)-1].Speed)
+
+	done := rec.filter(EventRequestDone, PhaseDownloadWarmUp)
+	assert.Equal(t, 2, len(done))
+	assert.Equal(t, int64(750*750*2), done[0].Bytes)
+	assert.NoError(t, done[0].Err)
+
+	// requests of the main phase cut short by the end of the window did not fail
+	done = rec.filter(EventRequestDone, PhaseDownload)
+	assert.Equal(t, 32, len(done))
+	for _, e := range done {
+		assert.NoError(t, e.Err)
+	}
+
+	// no progress is reported once a phase ended
+	for i, e := range rec.events {
+		if e.Type == EventPhaseEnd && i+1 < len(rec.events) {
+			assert.NotEqual(t, EventProgress, rec.events[i+1].Type, "progress after the end of %v", e.Phase)
+		}
+	}
+
+	progress := rec.filter(EventProgress, PhaseDownload)
+	assert.GreaterOrEqual(t, len(progress), 2, "got unexpected progress events %v", progress)
+	last := progress[len(progress)-1]
+	assert.Greater(t, last.Speed, 0.0)
+	assert.Greater(t, last.Bytes, int64(0))
+	assert.LessOrEqual(t, last.Bytes, server.DLBytes-2*750*750*2)
+}
+
+func TestUploadTestEventsOnError(t *testing.T) {
+	server := Server{
+		URL: "http://fake.com/upload.php",
+	}
+	rec := &recorder{}
+
+	err := server.uploadTestContext(
+		context.Background(),
+		mockTransport(mockTransfer(100*time.Millisecond), func(req *http.Request) (*http.Response, error) {
+			return nil, errors.New("connection reset")
+		}),
+		newTestSettings(WithObserver(rec.observe), withoutLoadedLatency),
+	)
+	assert.Error(t, err, "should expect error")
+
+	end := rec.filter(EventPhaseEnd, PhaseUpload)
+	assert.Equal(t, 1, len(end))
+	assert.Equal(t, err, end[0].Err)
+	assert.Equal(t, EventPhaseEnd, rec.events[len(rec.events)-1].Type)
+}
+
+func TestPingTestEvents(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	server := Server{
+		URL: "http://fake.com/upload.php",
+	}
+	rec := &recorder{}
+
+	client := &http.Client{}
+
+	httpmock.Activate()
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", "http://fake.com/latency.txt", fakeResponder(200, `test=test`, "text/plain"))
+
+	err := server.PingTestContext(context.Background(), client, WithObserver(rec.observe))
+	assert.NoError(t, err, "unexpected error %v", err)
+
+	assert.Equal(t, 1, len(rec.filter(EventPhaseStart, PhasePing)))
+	assert.Equal(t, 3, len(rec.filter(EventRequestDone, PhasePing)))
+	end := rec.filter(EventPhaseEnd, PhasePing)
+	assert.Equal(t, 1, len(end))
+	assert.Equal(t, server.Latency, end[0].Latency)
+}
+
+func TestChannelObserver(t *testing.T) {
+	ch := make(chan Event, 1)
+	o := ChannelObserver(ch)
+
+	o(Event{Type: EventPhaseStart})
+	// dropped rather than blocking
+	o(Event{Type: EventPhaseEnd})
+
+	assert.Equal(t, EventPhaseStart, (<-ch).Type)
+	assert.Equal(t, 0, len(ch))
+}
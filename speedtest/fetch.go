@@ -0,0 +1,147 @@
+package speedtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// FetchSettings holds the parameters user information and server lists are fetched with.
+type FetchSettings struct {
+	// ConfigURL is the location of speedtest-config.php, which user information is read from.
+	ConfigURL string
+	// ServerListURL is the location of the server list, an XML or JSON document with the schema of ServerList.
+	ServerListURL string
+	// Config drops the servers it says to ignore from server lists, if set.
+	Config *Config
+	// Origin overrides the location of the user servers are sorted around, if set.
+	Origin *Origin
+	// NoOrigin keeps the order of the server list, leaving distances at zero.
+	NoOrigin bool
+	// Cache keeps fetched documents on disk, if set.
+	Cache *Cache
+	// Refresh fetches documents even when the cache holds a fresh copy.
+	Refresh bool
+}
+
+// Origin is a location given in degrees.
+type Origin struct {
+	Lat float64
+	Lon float64
+}
+
+// FetchOption configures FetchSettings.
+type FetchOption func(*FetchSettings)
+
+// WithConfigURL fetches user information from url instead of speedtest.net.
+func WithConfigURL(url string) FetchOption {
+	return func(s *FetchSettings) {
+		s.ConfigURL = url
+	}
+}
+
+// WithServerListURL fetches the server list from url instead of speedtest.net.
+func WithServerListURL(url string) FetchOption {
+	return func(s *FetchSettings) {
+		s.ServerListURL = url
+	}
+}
+
+// WithConfig drops the servers cfg says to ignore from server lists.
+func WithConfig(cfg *Config) FetchOption {
+	return func(s *FetchSettings) {
+		s.Config = cfg
+	}
+}
+
+// WithOrigin sorts servers by distance to the given location instead of the location of the user.
+func WithOrigin(lat, lon float64) FetchOption {
+	return func(s *FetchSettings) {
+		s.Origin = &Origin{Lat: lat, Lon: lon}
+		s.NoOrigin = false
+	}
+}
+
+// WithoutOrigin keeps the order of the server list instead of sorting it by distance.
+func WithoutOrigin() FetchOption {
+	return func(s *FetchSettings) {
+		s.Origin = nil
+		s.NoOrigin = true
+	}
+}
+
+// WithCache uses fresh copies kept in c instead of fetching documents, and falls back
+// to older copies when fetching fails.
+func WithCache(c *Cache) FetchOption {
+	return func(s *FetchSettings) {
+		s.Cache = c
+	}
+}
+
+// WithRefresh fetches documents even when the cache holds a fresh copy.
+func WithRefresh() FetchOption {
+	return func(s *FetchSettings) {
+		s.Refresh = true
+	}
+}
+
+func newFetchSettings(opts ...FetchOption) FetchSettings {
+	s := FetchSettings{
+		ConfigURL:     speedTestConfigUrl,
+		ServerListURL: speedTestServersUrl,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
+}
+
+// fetch passes the document at url to decode, doing describes the fetch in errors.
+// A fresh copy of the cache is used instead of fetching, if any, and a stale one
+// when fetching or decoding fails. Only documents decode accepts are cached.
+func fetch(ctx context.Context, client Transport, url, doing string, settings FetchSettings, decode func([]byte) error) error {
+	c := settings.Cache
+	if c != nil && !settings.Refresh {
+		if data, fresh, err := c.load(url); err == nil && fresh && decode(data) == nil {
+			return nil
+		}
+	}
+
+	data, err := fetchDocument(ctx, client, url, doing)
+	if err == nil {
+		err = decode(data)
+	}
+	if err != nil {
+		if c != nil {
+			if data, _, cerr := c.load(url); cerr == nil && decode(data) == nil {
+				return nil
+			}
+		}
+		return err
+	}
+
+	if c != nil {
+		// The cache only saves a request, a copy which can not be stored is not an error.
+		_ = c.store(url, data)
+	}
+	return nil
+}
+
+func fetchDocument(ctx context.Context, client Transport, url, doing string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code %v while %s from %v", resp.StatusCode, doing, url)
+	}
+
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,152 @@
+package speedtest
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const fakeServerListResponse = `<settings>
+	<servers>
+	<server url="http://fake.com:8080/speedtest/upload.php" lat="35.22" lon="138.44" name="Fake" country="Taiwan" cc="TW" sponsor="Fake" id="1" host="fake.com:8080"/>
+	</servers>
+	</settings>`
+
+const fakeUserResponse = `<settings>
+	<client ip="211.72.129.103" lat="25.0504" lon="121.5324" isp="Chunghwa Telecom" country="TW"/>
+	</settings>`
+
+func TestFetchWithCache(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", speedTestServersUrl, fakeResponder(200, fakeServerListResponse, "application/xml"))
+	httpmock.RegisterResponder("GET", speedTestConfigUrl, fakeResponder(200, fakeUserResponse, "application/xml"))
+
+	cache := &Cache{Dir: t.TempDir(), TTL: time.Minute}
+	user := &User{Lat: "35.22", Lon: "138.44"}
+	for i := 0; i < 3; i++ {
+		u, err := FetchUserInfo(client, WithCache(cache))
+		assert.NoError(t, err, "unexpected error %v", err)
+		assert.Equal(t, "211.72.129.103", u.IP)
+
+		list, err := FetchServerList(client, user, WithCache(cache))
+		assert.NoError(t, err, "unexpected error %v", err)
+		assert.Equal(t, 1, len(list.Servers))
+		assert.Equal(t, "1", list.Servers[0].ID)
+	}
+	assert.Equal(t, 1, httpmock.GetCallCountInfo()["GET "+speedTestServersUrl], "fresh copies should be used")
+	assert.Equal(t, 1, httpmock.GetCallCountInfo()["GET "+speedTestConfigUrl], "fresh copies should be used")
+
+	_, err := FetchServerList(client, user, WithCache(cache), WithRefresh())
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 2, httpmock.GetCallCountInfo()["GET "+speedTestServersUrl], "refresh should bypass the cache")
+}
+
+func TestFetchWithStaleCache(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", speedTestServersUrl, fakeResponder(200, fakeServerListResponse, "application/xml"))
+
+	cache := &Cache{Dir: t.TempDir(), TTL: 0}
+	user := &User{Lat: "35.22", Lon: "138.44"}
+	_, err := FetchServerList(client, user, WithCache(cache))
+	assert.NoError(t, err, "unexpected error %v", err)
+
+	httpmock.RegisterResponder("GET", speedTestServersUrl, httpmock.NewErrorResponder(errors.New("network is unreachable")))
+	list, err := FetchServerList(client, user, WithCache(cache))
+	assert.NoError(t, err, "stale copy should be used, got %v", err)
+	assert.Equal(t, 1, len(list.Servers))
+	assert.Equal(t, distance(35.22, 138.44, 35.22, 138.44), list.Servers[0].Distance)
+
+	httpmock.RegisterResponder("GET", speedTestServersUrl, fakeResponder(200, `<settings></settings>`, "application/xml"))
+	list, err = FetchServerList(client, user, WithCache(cache))
+	assert.NoError(t, err, "stale copy should be used, got %v", err)
+	assert.Equal(t, 1, len(list.Servers))
+
+	_, err = FetchServerList(client, user, WithCache(&Cache{Dir: t.TempDir()}))
+	assert.Error(t, err, "should expect error without any copy")
+}
+
+func TestFetchWithCustomURLs(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", "http://fleet.local/config.php", fakeResponder(200, fakeUserResponse, "application/xml"))
+	httpmock.RegisterResponder("GET", "http://fleet.local/servers.json", fakeResponder(200, `{"servers": [
+		{"url": "http://far.local:8080/upload.php", "lat": "40", "lon": "140", "id": "2"},
+		{"url": "http://near.local:8080/upload.php", "lat": "25", "lon": "121.5", "id": "1"}
+	]}`, "application/json"))
+
+	user, err := FetchUserInfo(client, WithConfigURL("http://fleet.local/config.php"))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "211.72.129.103", user.IP)
+
+	list, err := FetchServerList(client, user, WithServerListURL("http://fleet.local/servers.json"))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 2, len(list.Servers))
+	assert.Equal(t, "1", list.Servers[0].ID)
+	assert.Equal(t, "http://near.local:8080/upload.php", list.Servers[0].URL)
+
+	_, err = FetchServerList(client, user, WithServerListURL("http://fleet.local/missing.xml"))
+	assert.Error(t, err)
+}
+
+func TestNewFetchSettings(t *testing.T) {
+	s := newFetchSettings()
+	assert.Equal(t, speedTestConfigUrl, s.ConfigURL)
+	assert.Equal(t, speedTestServersUrl, s.ServerListURL)
+	assert.Nil(t, s.Cache)
+	assert.False(t, s.Refresh)
+
+	cache := &Cache{}
+	s = newFetchSettings(WithConfigURL("a"), WithServerListURL("b"), WithCache(cache), WithRefresh())
+	assert.Equal(t, "a", s.ConfigURL)
+	assert.Equal(t, "b", s.ServerListURL)
+	assert.Equal(t, cache, s.Cache)
+	assert.True(t, s.Refresh)
+}
+
+func TestFetchServerListWithOrigin(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", speedTestServersUrl, fakeResponder(200, `<settings>
+	<servers>
+	<server url="http://taipei.com/upload.php" lat="25.05" lon="121.53" id="1"/>
+	<server url="http://tokyo.com/upload.php" lat="35.68" lon="139.69" id="2"/>
+	</servers>
+	</settings>`, "application/xml"))
+
+	user := &User{Lat: "25.0504", Lon: "121.5324"}
+	list, err := FetchServerList(client, user)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "1", list.Servers[0].ID)
+
+	list, err = FetchServerList(client, user, WithOrigin(35.6, 139.7))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "2", list.Servers[0].ID, "origin should override the user location")
+	assert.Equal(t, distance(35.68, 139.69, 35.6, 139.7), list.Servers[0].Distance)
+
+	list, err = FetchServerList(client, nil, WithOrigin(35.6, 139.7))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "2", list.Servers[0].ID, "origin should not need user information")
+
+	list, err = FetchServerList(client, user, WithOrigin(35.6, 139.7), WithoutOrigin())
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "1", list.Servers[0].ID, "order of the list should be kept")
+	assert.Equal(t, 0.0, list.Servers[1].Distance)
+
+	list, err = FetchServerList(client, nil)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "1", list.Servers[0].ID, "order of the list should be kept")
+}
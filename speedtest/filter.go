@@ -0,0 +1,155 @@
+package speedtest
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// ServerFilter selects servers of a ServerList.
+//
+// A server is kept when it matches at least one value of every non empty include list,
+// and no value of any exclude list. Country codes are compared case insensitively,
+// sponsors and names are case insensitive regular expressions, so that a plain word
+// matches as a substring.
+type ServerFilter struct {
+	Countries        []string `json:"countries,omitempty"`
+	ExcludeCountries []string `json:"exclude_countries,omitempty"`
+	Sponsors         []string `json:"sponsors,omitempty"`
+	ExcludeSponsors  []string `json:"exclude_sponsors,omitempty"`
+	Names            []string `json:"names,omitempty"`
+	ExcludeNames     []string `json:"exclude_names,omitempty"`
+	// HostSuffixes are compared to the host name of servers, without port.
+	HostSuffixes        []string `json:"host_suffixes,omitempty"`
+	ExcludeHostSuffixes []string `json:"exclude_host_suffixes,omitempty"`
+	// MaxDistance drops servers further away in km, zero keeps all.
+	// Servers without location, as those of LibreSpeed lists, are not dropped by distance.
+	MaxDistance float64 `json:"max_distance,omitempty"`
+	// Nearest keeps the first n matching servers of the list with a location, zero keeps all.
+	// Matching servers without location are all kept.
+	Nearest int `json:"nearest,omitempty"`
+}
+
+// Filter returns the servers of l matching f, in the order of l.
+func (l *ServerList) Filter(f ServerFilter) (ServerList, error) {
+	m, err := f.compile()
+	if err != nil {
+		return ServerList{}, err
+	}
+
+	list := ServerList{Servers: []*Server{}}
+	located := 0
+	for _, s := range l.Servers {
+		if !m.match(s) {
+			continue
+		}
+		if _, _, ok := s.location(); ok {
+			if f.Nearest > 0 && located >= f.Nearest {
+				continue
+			}
+			located++
+		}
+		list.Servers = append(list.Servers, s)
+	}
+
+	return list, nil
+}
+
+// serverMatcher is a ServerFilter with its patterns compiled.
+type serverMatcher struct {
+	filter          ServerFilter
+	sponsors        []*regexp.Regexp
+	excludeSponsors []*regexp.Regexp
+	names           []*regexp.Regexp
+	excludeNames    []*regexp.Regexp
+}
+
+func (f ServerFilter) compile() (*serverMatcher, error) {
+	m := &serverMatcher{filter: f}
+	var err error
+	if m.sponsors, err = compilePatterns(f.Sponsors); err != nil {
+		return nil, err
+	}
+	if m.excludeSponsors, err = compilePatterns(f.ExcludeSponsors); err != nil {
+		return nil, err
+	}
+	if m.names, err = compilePatterns(f.Names); err != nil {
+		return nil, err
+	}
+	if m.excludeNames, err = compilePatterns(f.ExcludeNames); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server filter pattern %q: %w", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func (m *serverMatcher) match(s *Server) bool {
+	f := m.filter
+	if _, _, ok := s.location(); ok && f.MaxDistance > 0 && s.Distance > f.MaxDistance {
+		return false
+	}
+	return includes(f.Countries, f.ExcludeCountries, s.CC, strings.EqualFold) &&
+		includes(f.HostSuffixes, f.ExcludeHostSuffixes, hostname(s.Host), hasSuffixFold) &&
+		matches(m.sponsors, m.excludeSponsors, s.Sponsor) &&
+		matches(m.names, m.excludeNames, s.Name)
+}
+
+// includes reports whether v equals one of include, if any, and none of exclude.
+func includes(include, exclude []string, v string, equal func(v, want string) bool) bool {
+	for _, want := range exclude {
+		if equal(v, want) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, want := range include {
+		if equal(v, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether v matches one of include, if any, and none of exclude.
+func matches(include, exclude []*regexp.Regexp, v string) bool {
+	for _, re := range exclude {
+		if re.MatchString(v) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, re := range include {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostname strips the port of host, if any.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+func hasSuffixFold(v, suffix string) bool {
+	return len(v) >= len(suffix) && strings.EqualFold(v[len(v)-len(suffix):], suffix)
+}
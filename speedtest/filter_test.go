@@ -0,0 +1,81 @@
+package speedtest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFilterList() ServerList {
+	return ServerList{Servers: []*Server{
+		{ID: "1", Lat: "25.0500", Lon: "121.5300", Name: "Taipei", CC: "TW", Sponsor: "Chunghwa Mobile", Host: "tp1.chtm.hinet.net:8080", Distance: 1.91},
+		{ID: "2", Lat: "25.0500", Lon: "121.5300", Name: "Taipei", CC: "TW", Sponsor: "Taiwan Fixed Network", Host: "speedtest.tfn.net.tw:8080", Distance: 1.91},
+		{ID: "3", Lat: "24.9700", Lon: "121.5400", Name: "新北", CC: "TW", Sponsor: "大新店", Host: "fake.com:8080", Distance: 3.85},
+		{ID: "4", Lat: "35.6800", Lon: "139.6900", Name: "Tokyo", CC: "JP", Sponsor: "IPA CyberLab", Host: "speed.cyberlab.jp", Distance: 2100},
+		{ID: "5", Lat: "22.3200", Lon: "114.1700", Name: "Hong Kong", CC: "HK", Sponsor: "HGC Global", Host: "hgc.com.hk:8080", Distance: 800},
+	}}
+}
+
+func filteredIDs(t *testing.T, f ServerFilter) []string {
+	list := newFilterList()
+	filtered, err := list.Filter(f)
+	assert.NoError(t, err, "unexpected error %v", err)
+	ids := []string{}
+	for _, s := range filtered.Servers {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+func TestFilter(t *testing.T) {
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, filteredIDs(t, ServerFilter{}))
+	assert.Equal(t, []string{"4", "5"}, filteredIDs(t, ServerFilter{Countries: []string{"jp", "HK"}}))
+	assert.Equal(t, []string{"4", "5"}, filteredIDs(t, ServerFilter{ExcludeCountries: []string{"tw"}}))
+	assert.Equal(t, []string{"1", "2"}, filteredIDs(t, ServerFilter{Names: []string{"taipei"}}))
+	assert.Equal(t, []string{"3", "4", "5"}, filteredIDs(t, ServerFilter{ExcludeNames: []string{"^Taipei$"}}))
+	assert.Equal(t, []string{"1", "3"}, filteredIDs(t, ServerFilter{Sponsors: []string{"chunghwa", "新"}}))
+	assert.Equal(t, []string{"1", "2", "5"}, filteredIDs(t, ServerFilter{ExcludeSponsors: []string{"新", "cyber"}}))
+	assert.Equal(t, []string{"1", "4"}, filteredIDs(t, ServerFilter{HostSuffixes: []string{".NET", "jp"}}))
+	assert.Equal(t, []string{"2", "3", "4", "5"}, filteredIDs(t, ServerFilter{ExcludeHostSuffixes: []string{"hinet.net"}}))
+	assert.Equal(t, []string{"1", "2", "3", "5"}, filteredIDs(t, ServerFilter{MaxDistance: 1000}))
+	assert.Equal(t, []string{"1", "2"}, filteredIDs(t, ServerFilter{Nearest: 2}))
+}
+
+func TestFilterCombined(t *testing.T) {
+	f := ServerFilter{
+		Countries:       []string{"TW", "JP"},
+		ExcludeSponsors: []string{"chunghwa"},
+		Nearest:         2,
+	}
+	assert.Equal(t, []string{"2", "3"}, filteredIDs(t, f))
+
+	f.MaxDistance = 2
+	assert.Equal(t, []string{"2"}, filteredIDs(t, f))
+}
+
+func TestFilterWithoutLocation(t *testing.T) {
+	list := ServerList{Servers: []*Server{
+		{ID: "1", Lat: "25.0500", Lon: "121.5300", Distance: 1.91},
+		{ID: "2", Lat: "35.6800", Lon: "139.6900", Distance: 2100},
+		{ID: "branch", Name: "Branch"},
+		{ID: "3", Lat: "22.3200", Lon: "114.1700", Distance: 800},
+	}}
+
+	// servers without location are not checked against distances
+	filtered, err := list.Filter(ServerFilter{MaxDistance: 1000, Nearest: 1})
+	assert.NoError(t, err, "unexpected error %v", err)
+	if assert.Equal(t, 2, len(filtered.Servers)) {
+		assert.Equal(t, "1", filtered.Servers[0].ID)
+		assert.Equal(t, "branch", filtered.Servers[1].ID)
+	}
+
+	filtered, err = list.Filter(ServerFilter{Names: []string{"nowhere"}, MaxDistance: 1000})
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 0, len(filtered.Servers))
+}
+
+func TestFilterWithInvalidPattern(t *testing.T) {
+	list := newFilterList()
+	_, err := list.Filter(ServerFilter{Sponsors: []string{"("}})
+	assert.Error(t, err)
+}
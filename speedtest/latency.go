@@ -0,0 +1,134 @@
+package speedtest
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+)
+
+// LatencyStats describes the distribution of round trip times.
+type LatencyStats struct {
+	Samples []time.Duration `json:"samples"`
+	Min     time.Duration   `json:"min"`
+	Max     time.Duration   `json:"max"`
+	Mean    time.Duration   `json:"mean"`
+	Median  time.Duration   `json:"median"`
+	StdDev  time.Duration   `json:"std_dev"`
+	// Jitter is the mean absolute difference of consecutive samples.
+	Jitter time.Duration `json:"jitter"`
+}
+
+// newLatencyStats computes the statistics of samples, in the order they were taken.
+func newLatencyStats(samples []time.Duration) LatencyStats {
+	stats := LatencyStats{Samples: append([]time.Duration{}, samples...)}
+	if len(samples) == 0 {
+		return stats
+	}
+
+	sorted := append([]time.Duration{}, samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	stats.Min = sorted[0]
+	stats.Max = sorted[len(sorted)-1]
+	if len(sorted)%2 == 1 {
+		stats.Median = sorted[len(sorted)/2]
+	} else {
+		stats.Median = (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	}
+
+	sum := 0.0
+	for _, s := range samples {
+		sum += float64(s)
+	}
+	mean := sum / float64(len(samples))
+	stats.Mean = time.Duration(mean)
+
+	variance := 0.0
+	for _, s := range samples {
+		variance += (float64(s) - mean) * (float64(s) - mean)
+	}
+	stats.StdDev = time.Duration(math.Sqrt(variance / float64(len(samples))))
+
+	if len(samples) > 1 {
+		diff := 0.0
+		for i := 1; i < len(samples); i++ {
+			diff += math.Abs(float64(samples[i] - samples[i-1]))
+		}
+		stats.Jitter = time.Duration(diff / float64(len(samples)-1))
+	}
+
+	return stats
+}
+
+// BufferbloatGrade rates how much latency increases under load.
+type BufferbloatGrade string
+
+// Grades from best to worst
+const (
+	GradeAPlus BufferbloatGrade = "A+"
+	GradeA     BufferbloatGrade = "A"
+	GradeB     BufferbloatGrade = "B"
+	GradeC     BufferbloatGrade = "C"
+	GradeD     BufferbloatGrade = "D"
+	GradeF     BufferbloatGrade = "F"
+)
+
+// gradeBufferbloat grades the increase of the median round trip time under load.
+func gradeBufferbloat(increase time.Duration) BufferbloatGrade {
+	switch {
+	case increase < 5*time.Millisecond:
+		return GradeAPlus
+	case increase < 30*time.Millisecond:
+		return GradeA
+	case increase < 60*time.Millisecond:
+		return GradeB
+	case increase < 200*time.Millisecond:
+		return GradeC
+	case increase < 400*time.Millisecond:
+		return GradeD
+	}
+	return GradeF
+}
+
+// probeFunc returns the round trip time of a single latency request.
+type probeFunc func(context.Context) (time.Duration, error)
+
+// latencyProbe keeps measuring latency in the background.
+type latencyProbe struct {
+	cancel  context.CancelFunc
+	done    chan struct{}
+	samples []time.Duration
+}
+
+// startLatencyProbe calls probe every interval until stopped. Failed probes are
+// not recorded, as requests may time out on a saturated link.
+func startLatencyProbe(ctx context.Context, probe probeFunc, interval time.Duration) *latencyProbe {
+	pCtx, cancel := context.WithCancel(ctx)
+	p := &latencyProbe{cancel: cancel, done: make(chan struct{})}
+	if probe == nil || interval <= 0 {
+		close(p.done)
+		return p
+	}
+
+	go func() {
+		defer close(p.done)
+		for {
+			if rtt, err := probe(pCtx); err == nil {
+				p.samples = append(p.samples, rtt)
+			}
+			select {
+			case <-pCtx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+	return p
+}
+
+// Stop stops probing, discarding a probe in flight, and returns the statistics of the samples taken.
+func (p *latencyProbe) Stop() LatencyStats {
+	p.cancel()
+	<-p.done
+	return newLatencyStats(p.samples)
+}
@@ -0,0 +1,76 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLatencyStats(t *testing.T) {
+	ms := time.Millisecond
+	stats := newLatencyStats([]time.Duration{10 * ms, 30 * ms, 20 * ms, 40 * ms})
+	assert.Equal(t, []time.Duration{10 * ms, 30 * ms, 20 * ms, 40 * ms}, stats.Samples)
+	assert.Equal(t, 10*ms, stats.Min)
+	assert.Equal(t, 40*ms, stats.Max)
+	assert.Equal(t, 25*ms, stats.Mean)
+	assert.Equal(t, 25*ms, stats.Median)
+	// sqrt((225 + 25 + 25 + 225) / 4)
+	assert.Equal(t, time.Duration(11180339), stats.StdDev)
+	// (20 + 10 + 20) / 3
+	assert.Equal(t, time.Duration(16666666), stats.Jitter)
+
+	stats = newLatencyStats([]time.Duration{10 * ms, 30 * ms, 20 * ms})
+	assert.Equal(t, 20*ms, stats.Median)
+}
+
+func TestNewLatencyStatsWithSingleSample(t *testing.T) {
+	stats := newLatencyStats([]time.Duration{time.Millisecond})
+	assert.Equal(t, time.Millisecond, stats.Min)
+	assert.Equal(t, time.Millisecond, stats.Max)
+	assert.Equal(t, time.Millisecond, stats.Median)
+	assert.Equal(t, time.Duration(0), stats.StdDev)
+	assert.Equal(t, time.Duration(0), stats.Jitter)
+}
+
+func TestNewLatencyStatsWithoutSamples(t *testing.T) {
+	stats := newLatencyStats(nil)
+	assert.Equal(t, LatencyStats{Samples: []time.Duration{}}, stats)
+}
+
+func TestGradeBufferbloat(t *testing.T) {
+	ms := time.Millisecond
+	assert.Equal(t, GradeAPlus, gradeBufferbloat(-ms))
+	assert.Equal(t, GradeAPlus, gradeBufferbloat(4*ms))
+	assert.Equal(t, GradeA, gradeBufferbloat(5*ms))
+	assert.Equal(t, GradeB, gradeBufferbloat(30*ms))
+	assert.Equal(t, GradeC, gradeBufferbloat(60*ms))
+	assert.Equal(t, GradeD, gradeBufferbloat(200*ms))
+	assert.Equal(t, GradeF, gradeBufferbloat(400*ms))
+}
+
+func TestLatencyProbe(t *testing.T) {
+	calls := 0
+	probe := func(ctx context.Context) (time.Duration, error) {
+		calls++
+		if calls%2 == 0 {
+			return 0, errors.New("timeout")
+		}
+		return time.Duration(calls) * time.Millisecond, nil
+	}
+
+	p := startLatencyProbe(context.Background(), probe, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	stats := p.Stop()
+
+	assert.GreaterOrEqual(t, len(stats.Samples), 3, "got unexpected samples %v", stats.Samples)
+	// failed probes are not recorded
+	assert.Equal(t, []time.Duration{time.Millisecond, 3 * time.Millisecond, 5 * time.Millisecond}, stats.Samples[:3])
+}
+
+func TestLatencyProbeDisabled(t *testing.T) {
+	p := startLatencyProbe(context.Background(), nil, 10*time.Millisecond)
+	assert.Equal(t, 0, len(p.Stop().Samples))
+}
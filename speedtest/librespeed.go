@@ -0,0 +1,183 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LibreSpeed holds the endpoints of a LibreSpeed server, as absolute URLs.
+type LibreSpeed struct {
+	// Download is garbage.php, which returns ckSize chunks of 1 MiB.
+	Download string `json:"download"`
+	// Upload is empty.php, which drains posted bodies.
+	Upload string `json:"upload"`
+	// Ping is empty.php too, by default.
+	Ping string `json:"ping"`
+}
+
+// libreSpeedChunk is the size of the chunks of garbage.php.
+const libreSpeedChunk = 1024 * 1024
+
+// libreSpeedServer is a server of a LibreSpeed server list.
+type libreSpeedServer struct {
+	// ID is a number in most lists
+	ID          interface{} `json:"id"`
+	Name        string      `json:"name"`
+	Server      string      `json:"server"`
+	DlURL       string      `json:"dlURL"`
+	UlURL       string      `json:"ulURL"`
+	PingURL     string      `json:"pingURL"`
+	SponsorName string      `json:"sponsorName"`
+}
+
+// decodeLibreSpeedServerList decodes the JSON array of a LibreSpeed server list. Servers whose
+// URL starts with // use https.
+func decodeLibreSpeedServerList(data []byte) (ServerList, error) {
+	var servers []libreSpeedServer
+	if err := json.Unmarshal(data, &servers); err != nil {
+		return ServerList{}, err
+	}
+
+	list := ServerList{Servers: make([]*Server, 0, len(servers))}
+	for _, ls := range servers {
+		base := ls.Server
+		if strings.HasPrefix(base, "//") {
+			base = "https:" + base
+		}
+		u, err := url.Parse(base)
+		if err != nil {
+			return ServerList{}, fmt.Errorf("invalid server %q of LibreSpeed server list: %w", ls.Server, err)
+		}
+
+		endpoints := newLibreSpeed(base, ls.DlURL, ls.UlURL, ls.PingURL)
+		id := ""
+		if ls.ID != nil {
+			id = fmt.Sprint(ls.ID)
+		}
+		list.Servers = append(list.Servers, &Server{
+			URL:        base,
+			Name:       ls.Name,
+			Sponsor:    ls.SponsorName,
+			ID:         id,
+			Host:       u.Host,
+			LibreSpeed: &endpoints,
+		})
+	}
+	return list, nil
+}
+
+// newLibreSpeed resolves the endpoints of a LibreSpeed server against its base URL,
+// empty ones being those of a default installation.
+func newLibreSpeed(base, download, upload, ping string) LibreSpeed {
+	if download == "" {
+		download = "garbage.php"
+	}
+	if upload == "" {
+		upload = "empty.php"
+	}
+	if ping == "" {
+		ping = "empty.php"
+	}
+	return LibreSpeed{
+		Download: resolveURL(base, download),
+		Upload:   resolveURL(base, upload),
+		Ping:     resolveURL(base, ping),
+	}
+}
+
+// resolveURL resolves ref against base, or appends it when base is not a valid URL.
+func resolveURL(base, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return base + ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return base + ref
+	}
+	if b.Path == "" {
+		b.Path = "/"
+	}
+	return b.ResolveReference(r).String()
+}
+
+// libreSpeedEndpoints returns the LibreSpeed endpoints of s, those of a default installation
+// in the directory of its URL when it has none.
+func (s *Server) libreSpeedEndpoints() LibreSpeed {
+	if s.LibreSpeed != nil {
+		return *s.LibreSpeed
+	}
+	return newLibreSpeed(s.URL, "", "", "")
+}
+
+// libreSpeedBackend speaks the protocol of LibreSpeed.
+type libreSpeedBackend struct {
+	client    Transport
+	url       string
+	endpoints LibreSpeed
+}
+
+func (b *libreSpeedBackend) endpoint() string {
+	return b.url
+}
+
+func (b *libreSpeedBackend) pingSamples(ctx context.Context, settings TestSettings, events *emitter) ([]time.Duration, error) {
+	return httpPingSamples(ctx, b.client, b.endpoints.Ping, settings, events)
+}
+
+func (b *libreSpeedBackend) latencyProbe() (probeFunc, func()) {
+	return httpLatencyProbe(b.client, b.endpoints.Ping)
+}
+
+func (b *libreSpeedBackend) download() (transferFunc, transferFunc) {
+	request := func(ctx context.Context, w int, c *counter) error {
+		return libreSpeedDownloadRequest(ctx, b.client, b.endpoints.Download, w, c)
+	}
+	return request, request
+}
+
+func (b *libreSpeedBackend) upload() (transferFunc, transferFunc) {
+	request := func(ctx context.Context, w int, c *counter) error {
+		return uploadRequest(ctx, b.client, b.endpoints.Upload, w, c)
+	}
+	return request, request
+}
+
+// libreSpeedDownloadRequest downloads the chunks of garbage.php covering the random image of weight w.
+func libreSpeedDownloadRequest(ctx context.Context, client Transport, dlURL string, w int, c *counter) error {
+	size := dlSizes[w]
+	chunks := (2*size*size + libreSpeedChunk - 1) / libreSpeedChunk
+
+	sep := "?"
+	if strings.Contains(dlURL, "?") {
+		sep = "&"
+	}
+	xdlURL := dlURL + sep + "ckSize=" + strconv.Itoa(chunks)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, xdlURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status code %v while downloading from %v", resp.StatusCode, xdlURL)
+	}
+
+	buf := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(buf)
+
+	_, err = io.CopyBuffer(c, resp.Body, *buf)
+	return err
+}
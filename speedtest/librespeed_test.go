@@ -0,0 +1,127 @@
+package speedtest
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadLibreSpeedServerList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`[
+		{"name": "Taipei", "server": "//taipei.example.com/backend/", "id": 1, "dlURL": "garbage.php", "ulURL": "empty.php", "pingURL": "empty.php", "getIpURL": "getIP.php", "sponsorName": "Branch"},
+		{"name": "Kaohsiung", "server": "http://10.0.0.2:8080/", "id": "ks", "dlURL": "backend/garbage.php?cors=true", "ulURL": "backend/empty.php", "pingURL": "", "getIpURL": "backend/getIP.php"}
+	]`), 0644))
+
+	list, err := LoadServerList(path, &User{Lat: "25.0504", Lon: "121.5324"})
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 2, len(list.Servers))
+
+	s := list.Servers[0]
+	assert.Equal(t, "1", s.ID)
+	assert.Equal(t, "Taipei", s.Name)
+	assert.Equal(t, "Branch", s.Sponsor)
+	assert.Equal(t, "https://taipei.example.com/backend/", s.URL)
+	assert.Equal(t, "taipei.example.com", s.Host)
+	assert.Equal(t, &LibreSpeed{
+		Download: "https://taipei.example.com/backend/garbage.php",
+		Upload:   "https://taipei.example.com/backend/empty.php",
+		Ping:     "https://taipei.example.com/backend/empty.php",
+	}, s.LibreSpeed)
+
+	s = list.Servers[1]
+	assert.Equal(t, "ks", s.ID, "order should be kept for servers without location")
+	assert.Equal(t, "10.0.0.2:8080", s.Host)
+	assert.Equal(t, &LibreSpeed{
+		Download: "http://10.0.0.2:8080/backend/garbage.php?cors=true",
+		Upload:   "http://10.0.0.2:8080/backend/empty.php",
+		Ping:     "http://10.0.0.2:8080/empty.php",
+	}, s.LibreSpeed)
+
+	_, err = decodeServerList([]byte(`[{"name": "broken", "server": "http://[::1"}]`))
+	assert.Error(t, err)
+}
+
+func TestResolveURL(t *testing.T) {
+	cases := []struct {
+		base, ref, want string
+	}{
+		{"http://fake.com", "garbage.php", "http://fake.com/garbage.php"},
+		{"http://fake.com/backend/", "garbage.php", "http://fake.com/backend/garbage.php"},
+		{"http://fake.com/speedtest/upload.php", "garbage.php", "http://fake.com/speedtest/garbage.php"},
+		{"http://fake.com/backend/", "/empty.php", "http://fake.com/empty.php"},
+		{"http://fake.com/backend/", "http://other.com/empty.php", "http://other.com/empty.php"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, resolveURL(c.base, c.ref), "%v + %v", c.base, c.ref)
+	}
+}
+
+func TestRunLibreSpeed(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	client := &http.Client{}
+
+	var mu sync.Mutex
+	chunks := map[string]bool{}
+	httpmock.Activate()
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", "http://fake.com/backend/empty.php", fakeResponder(200, "", "text/plain"))
+	httpmock.RegisterResponder("GET", "http://fake.com/backend/garbage.php", func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		chunks[req.URL.Query().Get("ckSize")] = true
+		mu.Unlock()
+		n, _ := strconv.Atoi(req.URL.Query().Get("ckSize"))
+		return httpmock.NewStringResponse(200, strings.Repeat("x", 1000*n)), nil
+	})
+	httpmock.RegisterResponder("POST", "http://fake.com/backend/empty.php", fakeResponder(200, "", "text/plain"))
+
+	list, err := decodeServerList([]byte(`[{"name": "fake", "server": "http://fake.com/backend/", "id": 3}]`))
+	assert.NoError(t, err, "unexpected error %v", err)
+
+	res, err := list.Servers[0].Run(client, WithDuration(100*time.Millisecond))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "3", res.Server.ID)
+	assert.NotNil(t, res.Server.LibreSpeed)
+	assert.Equal(t, "http://fake.com/backend/", res.Ping.URL)
+	assert.Greater(t, int64(res.Ping.Latency), int64(0))
+	assert.Greater(t, res.Download.Speed, 0.0)
+	assert.Greater(t, res.Upload.Speed, 0.0)
+	assert.Greater(t, len(res.Download.Latency.Samples), 0)
+
+	// downloads request the chunks covering the random images of the legacy protocol
+	want := map[string]bool{}
+	for _, size := range dlSizes {
+		want[strconv.Itoa((2*size*size+libreSpeedChunk-1)/libreSpeedChunk)] = true
+	}
+	mu.Lock()
+	assert.Greater(t, len(chunks), 0)
+	for n := range chunks {
+		assert.True(t, want[n], "unexpected ckSize %v", n)
+	}
+	mu.Unlock()
+	assert.Equal(t, 0, httpmock.GetCallCountInfo()["GET http://fake.com/backend/latency.txt"])
+}
+
+func TestNewBackend(t *testing.T) {
+	s := Server{URL: "http://fake.com/speedtest/upload.php", Host: "fake.com:8080"}
+
+	assert.IsType(t, &ooklaBackend{}, s.newBackend(nil, TestSettings{}))
+	assert.IsType(t, &socketBackend{}, s.newBackend(nil, TestSettings{Protocol: ProtocolTCP}))
+
+	b := s.newBackend(nil, TestSettings{Protocol: ProtocolLibreSpeed})
+	assert.IsType(t, &libreSpeedBackend{}, b)
+	assert.Equal(t, "http://fake.com/speedtest/garbage.php", b.(*libreSpeedBackend).endpoints.Download)
+
+	s.LibreSpeed = &LibreSpeed{Download: "http://fake.com/dl", Upload: "http://fake.com/ul", Ping: "http://fake.com/ping"}
+	b = s.newBackend(nil, TestSettings{Protocol: ProtocolTCP})
+	assert.IsType(t, &libreSpeedBackend{}, b, "servers of LibreSpeed lists should speak LibreSpeed")
+	assert.Equal(t, *s.LibreSpeed, b.(*libreSpeedBackend).endpoints)
+}
@@ -0,0 +1,77 @@
+package speedtest
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// payloadPrefix is the form field upload.php reads the uploaded content from.
+const payloadPrefix = "content="
+
+// payloadAlphabet holds 64 characters which need no escaping in a form encoded body.
+const payloadAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+var payloadSeed uint64
+
+// payload is an io.Reader producing a form encoded upload body of a fixed size.
+// The content is pseudo-random over 64 characters, 6 bits of entropy per byte, so that
+// compressing middleboxes can shrink it to no less than 75% and inflate the result by at
+// most a third, instead of many times for repeated text. It is generated straight into the
+// buffer passed to Read without allocating.
+type payload struct {
+	size  int64
+	off   int64
+	state uint64
+}
+
+// newPayload returns a payload of size bytes, including the form field name.
+func newPayload(size int64) *payload {
+	seed := uint64(time.Now().UnixNano()) ^ atomic.AddUint64(&payloadSeed, 0x9E3779B97F4A7C15)
+	if seed == 0 {
+		seed = 1
+	}
+	return &payload{size: size, state: seed}
+}
+
+// Len returns the number of bytes not yet read.
+func (p *payload) Len() int64 {
+	return p.size - p.off
+}
+
+func (p *payload) Read(b []byte) (int, error) {
+	if p.off >= p.size {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > p.Len() {
+		b = b[:p.Len()]
+	}
+
+	n := 0
+	for n < len(b) && p.off < int64(len(payloadPrefix)) {
+		b[n] = payloadPrefix[p.off]
+		n++
+		p.off++
+	}
+
+	for n < len(b) {
+		// Every 64 bit random number yields 10 characters of 6 bits.
+		x := p.next()
+		for i := 0; i < 10 && n < len(b); i++ {
+			b[n] = payloadAlphabet[x&63]
+			x >>= 6
+			n++
+			p.off++
+		}
+	}
+
+	return n, nil
+}
+
+// next advances the xorshift64* generator.
+func (p *payload) next() uint64 {
+	p.state ^= p.state >> 12
+	p.state ^= p.state << 25
+	p.state ^= p.state >> 27
+	return p.state * 2685821657736338717
+}
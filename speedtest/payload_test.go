@@ -0,0 +1,70 @@
+package speedtest
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayload(t *testing.T) {
+	body, err := io.ReadAll(newPayload(100 * 1000))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 100*1000, len(body))
+
+	v, err := url.ParseQuery(string(body))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 100*1000-len(payloadPrefix), len(v.Get("content")))
+}
+
+func TestPayloadShortReads(t *testing.T) {
+	p := newPayload(25)
+	b := make([]byte, 3)
+	body := []byte{}
+	for {
+		n, err := p.Read(b)
+		body = append(body, b[:n]...)
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err, "unexpected error %v", err)
+	}
+	assert.Equal(t, 25, len(body))
+	assert.Equal(t, payloadPrefix, string(body[:len(payloadPrefix)]))
+	assert.Equal(t, int64(0), p.Len())
+}
+
+func TestPayloadResistsCompression(t *testing.T) {
+	body, _ := io.ReadAll(newPayload(1000 * 1000))
+
+	var compressed bytes.Buffer
+	w, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	_, _ = w.Write(body)
+	_ = w.Close()
+
+	// 6 bits of entropy per character can not be compressed below 75%
+	assert.Greater(t, compressed.Len(), len(body)*74/100, "payload compressed to %v bytes", compressed.Len())
+}
+
+func TestPayloadIsNotRepeated(t *testing.T) {
+	a, _ := io.ReadAll(newPayload(1000))
+	b, _ := io.ReadAll(newPayload(1000))
+	assert.NotEqual(t, a, b)
+}
+
+func BenchmarkPayloadRead(b *testing.B) {
+	p := newPayload(int64(b.N) * 32 * 1024)
+	buf := make([]byte, 32*1024)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Read(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -3,241 +3,543 @@ package speedtest
 import (
 	"context"
 	"fmt"
-	"net/url"
+	"io"
+	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/go-resty/resty/v2"
 	"golang.org/x/sync/errgroup"
 )
 
-type downloadFunc func(context.Context, *resty.Client, string, int) error
-type uploadFunc func(context.Context, *resty.Client, string, int) error
+// transferFunc performs a single request of the given weight, counting the bytes moved in c.
+type transferFunc func(context.Context, int, *counter) error
+
+// copyBufPool holds the buffers used to stream response bodies.
+var copyBufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 32*1024)
+		return &b
+	},
+}
 
 var dlSizes = [...]int{350, 500, 750, 1000, 1500, 2000, 2500, 3000, 3500, 4000}
 var ulSizes = [...]int{100, 300, 500, 800, 1000, 1500, 2500, 3000, 3500, 4000} //kB
 
+// workload is the number of parallel streams and the request weight used above a warm up speed.
+type workload struct {
+	minSpeed float64
+	streams  int
+	weight   int
+}
+
+// Workloads are decided by warm up speed, links slower than the last tier skip the main phase.
+var dlWorkloads = []workload{{50.0, 32, 6}, {10.0, 16, 4}, {4.0, 8, 4}, {2.5, 4, 4}}
+var ulWorkloads = []workload{{50.0, 40, 9}, {10.0, 16, 9}, {4.0, 8, 9}, {2.5, 4, 5}}
+
+// transferTest describes one direction of a throughput test.
+type transferTest struct {
+	name           string
+	url            string
+	warmUpPhase    Phase
+	phase          Phase
+	wuWeight       int
+	workloads      []workload
+	streams        int
+	duration       time.Duration
+	sampleInterval time.Duration
+	latency        time.Duration
+	probe          probeFunc
+	probeInterval  time.Duration
+	events         *emitter
+	warmUp         transferFunc
+	request        transferFunc
+	// drain lets requests in flight at the end of a timed window finish, for requests
+	// whose bytes are only counted once they completed.
+	drain bool
+}
+
 // DownloadTest executes the test to measure download speed
-func (s *Server) DownloadTest(client *resty.Client) error {
-	return s.downloadTestContext(context.Background(), client, downloadRequest, downloadRequest)
+func (s *Server) DownloadTest(client Transport, opts ...TestOption) error {
+	return s.DownloadTestContext(context.Background(), client, opts...)
+}
+
+// DownloadTestContext executes the test to measure download speed, observing the given context.
+// In-flight requests are stopped as soon as the context is done.
+func (s *Server) DownloadTestContext(ctx context.Context, client Transport, opts ...TestOption) error {
+	return s.downloadTestContext(ctx, client, newTestSettings(opts...))
+}
+
+func (s *Server) downloadTestContext(ctx context.Context, client Transport, settings TestSettings) error {
+	res, err := s.download(ctx, client, settings, newEmitter(settings.Observer, s), s.Latency)
+	if err != nil {
+		return err
+	}
+
+	s.DLSpeed = res.Speed
+	s.DLBytes = res.Bytes
+	s.DLSamples = res.Samples
+	return nil
 }
 
-func (s *Server) downloadTestContext(
+// download measures download speed without modifying s.
+func (s *Server) download(
 	ctx context.Context,
-	client *resty.Client,
-	dlWarmUp downloadFunc,
-	downloadRequest downloadFunc,
-) error {
-	dlURL := strings.Split(s.URL, "/upload.php")[0]
-	eg := errgroup.Group{}
+	client Transport,
+	settings TestSettings,
+	events *emitter,
+	latency time.Duration,
+) (TransferResult, error) {
+	b := s.newBackend(client, settings)
+	probe, closeProbe := b.latencyProbe()
+	defer closeProbe()
+	warmUp, request := b.download()
+
+	t := transferTest{
+		name:           "download",
+		url:            b.endpoint(),
+		warmUpPhase:    PhaseDownloadWarmUp,
+		phase:          PhaseDownload,
+		wuWeight:       2,
+		workloads:      dlWorkloads,
+		streams:        settings.DownloadStreams,
+		duration:       settings.DownloadDuration,
+		sampleInterval: settings.SampleInterval,
+		latency:        latency,
+		probe:          probe,
+		probeInterval:  settings.LoadedLatencyInterval,
+		events:         events,
+		warmUp:         warmUp,
+		request:        request,
+	}
+
+	return runTransferTest(ctx, t)
+}
+
+// UploadTest executes the test to measure upload speed
+func (s *Server) UploadTest(client Transport, opts ...TestOption) error {
+	return s.UploadTestContext(context.Background(), client, opts...)
+}
+
+// UploadTestContext executes the test to measure upload speed, observing the given context.
+// In-flight requests are stopped as soon as the context is done.
+func (s *Server) UploadTestContext(ctx context.Context, client Transport, opts ...TestOption) error {
+	return s.uploadTestContext(ctx, client, newTestSettings(opts...))
+}
+
+func (s *Server) uploadTestContext(ctx context.Context, client Transport, settings TestSettings) error {
+	res, err := s.upload(ctx, client, settings, newEmitter(settings.Observer, s), s.Latency)
+	if err != nil {
+		return err
+	}
+
+	s.ULSpeed = res.Speed
+	s.ULBytes = res.Bytes
+	s.ULSamples = res.Samples
+	return nil
+}
+
+// upload measures upload speed without modifying s.
+func (s *Server) upload(
+	ctx context.Context,
+	client Transport,
+	settings TestSettings,
+	events *emitter,
+	latency time.Duration,
+) (TransferResult, error) {
+	b := s.newBackend(client, settings)
+	probe, closeProbe := b.latencyProbe()
+	defer closeProbe()
+	warmUp, request := b.upload()
+
+	t := transferTest{
+		name:           "upload",
+		url:            b.endpoint(),
+		warmUpPhase:    PhaseUploadWarmUp,
+		phase:          PhaseUpload,
+		wuWeight:       4,
+		workloads:      ulWorkloads,
+		streams:        settings.UploadStreams,
+		duration:       settings.UploadDuration,
+		sampleInterval: settings.SampleInterval,
+		latency:        latency,
+		probe:          probe,
+		probeInterval:  settings.LoadedLatencyInterval,
+		events:         events,
+		warmUp:         warmUp,
+		request:        request,
+		drain:          true,
+	}
+
+	return runTransferTest(ctx, t)
+}
 
+// runTransferTest warms up with two requests, then runs the main phase with a workload
+// decided by the warm up speed.
+func runTransferTest(ctx context.Context, t transferTest) (TransferResult, error) {
+	res := TransferResult{}
+	wuBytes, bytes := &counter{}, &counter{}
+	sTime := time.Now()
+	smp := startSampler(t.sampleInterval, t.events.progress, wuBytes, bytes)
+	err := runTransferPhases(ctx, t, smp, wuBytes, bytes, &res)
+	samples := smp.Stop()
+	if err != nil {
+		return TransferResult{}, checkCancelled(ctx, t.name, err)
+	}
+
+	res.URL = t.url
+	res.Bytes = wuBytes.Load() + bytes.Load()
+	res.Duration = time.Since(sTime)
+	res.Samples = samples
+	return res, nil
+}
+
+// runTransferPhases runs the warm up and main phases, counting bytes in wuBytes and bytes respectively.
+// It sets the speed and warm up duration of res. smp is stopped before the main phase ends, so that
+// no progress is reported after it.
+func runTransferPhases(ctx context.Context, t transferTest, smp *sampler, wuBytes, bytes *counter, res *TransferResult) error {
 	// Warming up
-	wuWeight := 2
+	t.events.startPhase(t.warmUpPhase, 0)
+	warmUp := t.events.observe(t.warmUp)
 	sTime := time.Now()
+	eg, egCtx := errgroup.WithContext(ctx)
 	for i := 0; i < 2; i++ {
 		eg.Go(func() error {
-			return dlWarmUp(ctx, client, dlURL, wuWeight)
+			return warmUp(egCtx, t.wuWeight, wuBytes)
 		})
 	}
 	if err := eg.Wait(); err != nil {
+		t.events.endPhase(Event{Bytes: wuBytes.Load(), Err: err})
 		return err
 	}
 	fTime := time.Now()
-	// 1.125MB for each request (750 * 750 * 2  / 1000 / 1000)
-	reqMB := float64(dlSizes[wuWeight]) * float64(dlSizes[wuWeight]) * 2.0 / 1000.0 / 1000.0
-	// Calculate speed in Mbps
-	wuSpeed := float64(reqMB) * 8.0 * 2.0 / fTime.Sub(sTime.Add(s.Latency)).Seconds()
+	res.WarmUpDuration = fTime.Sub(sTime)
+	wuSpeed := mbps(wuBytes.Load(), fTime.Sub(sTime.Add(t.latency)))
+	t.events.endPhase(Event{Bytes: wuBytes.Load(), Speed: wuSpeed})
 
 	// Decide workload by warm up speed
-	workload := 0
-	weight := 0
-	skip := false
-	switch {
-	case wuSpeed > 50.0:
-		workload = 32
-		weight = 6
-	case wuSpeed > 10.0:
-		workload = 16
-		weight = 4
-	case wuSpeed > 4.0:
-		workload = 8
-		weight = 4
-	case wuSpeed > 2.5:
-		workload = 4
-		weight = 4
-	default:
-		skip = true
+	wl := workload{}
+	for _, w := range t.workloads {
+		if wuSpeed > w.minSpeed {
+			wl = w
+			break
+		}
 	}
 
-	// Main speedtest
-	dlSpeed := wuSpeed
-	if !skip {
-		sTime = time.Now()
-		for i := 0; i < workload; i++ {
-			eg.Go(func() error {
-				return downloadRequest(ctx, client, dlURL, weight)
-			})
-		}
-		if err := eg.Wait(); err != nil {
-			return err
+	// A fixed number of streams overrides the one of the workload, even on slow links.
+	if t.streams > 0 {
+		if wl.streams == 0 {
+			wl.weight = t.wuWeight
 		}
-		fTime = time.Now()
+		wl.streams = t.streams
+	}
 
-		reqMB := float64(dlSizes[weight]) * float64(dlSizes[weight]) * 2.0 / 1000.0 / 1000.0
-		dlSpeed = float64(reqMB) * 8 * float64(workload) / fTime.Sub(sTime.Add(s.Latency)).Seconds()
+	// Main speedtest
+	if t.duration == 0 && wl.streams == 0 {
+		res.Speed = wuSpeed
+		return nil
 	}
 
-	s.DLSpeed = dlSpeed
-	return nil
+	t.events.startPhase(t.phase, wuBytes.Load())
+	probe := startLatencyProbe(ctx, t.probe, t.probeInterval)
+	speed, err := runMainPhase(ctx, t, wl, bytes)
+	res.Latency = probe.Stop()
+	smp.Stop()
+	t.events.endPhase(Event{Bytes: bytes.Load(), Speed: speed, Err: err})
+	res.Speed = speed
+	return err
 }
 
-// UploadTest executes the test to measure upload speed
-func (s *Server) UploadTest(client *resty.Client) error {
-	return s.uploadTestContext(context.Background(), client, uploadRequest, uploadRequest)
-}
+// runMainPhase runs the main phase with the given workload, counting bytes in c.
+// It returns the speed in Mbps.
+func runMainPhase(ctx context.Context, t transferTest, wl workload, c *counter) (float64, error) {
+	if t.duration > 0 {
+		if wl.streams == 0 {
+			// Slow links still run for the whole duration, at the warm up workload.
+			wl = workload{streams: 2, weight: t.wuWeight}
+		}
+		elapsed, err := runTimed(ctx, t.duration, wl, t.request, t.drain, t.events, c)
+		if err != nil {
+			return 0, err
+		}
+		return mbps(c.Load(), elapsed), nil
+	}
 
-func (s *Server) uploadTestContext(
-	ctx context.Context,
-	client *resty.Client,
-	ulWarmUp uploadFunc,
-	uploadRequest uploadFunc,
-) error {
-	// Warm up
-	wuWeight := 4
+	request := t.events.observe(t.request)
 	sTime := time.Now()
-	eg := errgroup.Group{}
-	for i := 0; i < 2; i++ {
+	eg, egCtx := errgroup.WithContext(ctx)
+	for i := 0; i < wl.streams; i++ {
 		eg.Go(func() error {
-			return ulWarmUp(ctx, client, s.URL, wuWeight)
+			return request(egCtx, wl.weight, c)
 		})
 	}
 	if err := eg.Wait(); err != nil {
-		return err
+		return 0, err
 	}
 	fTime := time.Now()
-	// 1.0 MB for each request
-	reqMB := float64(ulSizes[wuWeight]) / 1000.0
-	wuSpeed := reqMB * 8 * 2.0 / fTime.Sub(sTime.Add(s.Latency)).Seconds()
 
-	// Decide workload by warm up speed
-	workload := 0
-	weight := 0
-	skip := false
-	switch {
-	case wuSpeed > 50.0:
-		workload = 40
-		weight = 9
-	case wuSpeed > 10.0:
-		workload = 16
-		weight = 9
-	case wuSpeed > 4.0:
-		workload = 8
-		weight = 9
-	case wuSpeed > 2.5:
-		workload = 4
-		weight = 5
-	default:
-		skip = true
+	return mbps(c.Load(), fTime.Sub(sTime.Add(t.latency))), nil
+}
+
+// runTimed keeps every stream of the workload issuing requests until d has elapsed.
+// Requests still in flight at the end of the window are cut short, the bytes they
+// moved so far are counted, and they are reported to events as completed rather than failed.
+// With drain, they are given up to another d to complete instead, and the window lasts
+// until the last of them did.
+// It returns the actual length of the window.
+func runTimed(ctx context.Context, d time.Duration, wl workload, request transferFunc, drain bool, events *emitter, c *counter) (time.Duration, error) {
+	tCtx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	rCtx := tCtx
+	if drain {
+		var cancelDrain context.CancelFunc
+		rCtx, cancelDrain = context.WithTimeout(ctx, 2*d)
+		defer cancelDrain()
 	}
 
-	// Main speedtest
-	ulSpeed := wuSpeed
-	if !skip {
-		sTime = time.Now()
-		for i := 0; i < workload; i++ {
-			eg.Go(func() error {
-				return uploadRequest(ctx, client, s.URL, weight)
-			})
+	timed := request
+	request = events.observe(func(reqCtx context.Context, w int, rc *counter) error {
+		err := timed(reqCtx, w, rc)
+		if err != nil && rCtx.Err() != nil && ctx.Err() == nil {
+			return nil
 		}
-		if err := eg.Wait(); err != nil {
-			return err
-		}
-		fTime = time.Now()
+		return err
+	})
 
-		reqMB := float64(ulSizes[weight]) / 1000.0
-		ulSpeed = reqMB * 8 * float64(workload) / fTime.Sub(sTime.Add(s.Latency)).Seconds()
+	sTime := time.Now()
+	eg, egCtx := errgroup.WithContext(rCtx)
+	for i := 0; i < wl.streams; i++ {
+		eg.Go(func() error {
+			for egCtx.Err() == nil && tCtx.Err() == nil {
+				if err := request(egCtx, wl.weight, c); err != nil && rCtx.Err() == nil {
+					return err
+				}
+			}
+			return nil
+		})
 	}
+	err := eg.Wait()
+	elapsed := time.Since(sTime)
 
-	s.ULSpeed = ulSpeed
-
-	return nil
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return elapsed, err
 }
 
-func downloadRequest(ctx context.Context, client *resty.Client, dlURL string, w int) error {
+func downloadRequest(ctx context.Context, client Transport, dlURL string, w int, c *counter) error {
 	size := dlSizes[w]
 	xdlURL := dlURL + "/random" + strconv.Itoa(size) + "x" + strconv.Itoa(size) + ".jpg"
 
-	resp, err := client.R().
-		SetContext(ctx).
-		Get(xdlURL)
-
+	// The body is streamed into the counter rather than buffered in memory.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, xdlURL, nil)
 	if err != nil {
 		return err
 	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-	if resp.StatusCode() != 200 {
-		return fmt.Errorf("unexpected status code %v while downloading from %v", resp.StatusCode(), xdlURL)
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status code %v while downloading from %v", resp.StatusCode, xdlURL)
 	}
 
+	buf := copyBufPool.Get().(*[]byte)
+	defer copyBufPool.Put(buf)
+
+	_, err = io.CopyBuffer(c, resp.Body, *buf)
 	return err
 }
 
-func uploadRequest(ctx context.Context, client *resty.Client, ulURL string, w int) error {
-	size := ulSizes[w]
-	v := url.Values{}
-	v.Add("content", strings.Repeat("0123456789", size*100-51))
+// uploadRequest posts a payload of weight w to ulURL. Its bytes are counted once the server
+// acknowledged them, by the size=N upload.php replies with, or by the whole payload for the
+// empty replies of empty.php. Bytes read from the payload may still wait in socket buffers.
+func uploadRequest(ctx context.Context, client Transport, ulURL string, w int, c *counter) error {
+	body := newPayload(int64(ulSizes[w]) * 1000)
 
-	resp, err := client.R().
-		SetContext(ctx).
-		SetBody(v.Encode()).
-		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		Post(ulURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ulURL, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = body.Len()
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
 
-	if resp.StatusCode() != 200 {
-		return fmt.Errorf("unexpected status code %v while uploading to %v", resp.StatusCode(), ulURL)
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status code %v while uploading to %v", resp.StatusCode, ulURL)
 	}
 
-	return err
+	c.Add(acknowledgedSize(string(reply), req.ContentLength))
+	return nil
+}
+
+// acknowledgedSize returns the size an upload.php reply holds, or n if it holds none.
+func acknowledgedSize(reply string, n int64) int64 {
+	reply = strings.TrimSpace(reply)
+	if !strings.HasPrefix(reply, "size=") {
+		return n
+	}
+	size, err := strconv.ParseInt(strings.TrimPrefix(reply, "size="), 10, 64)
+	if err != nil {
+		return n
+	}
+	return size
 }
 
 // PingTest executes test to measure latency
-func (s *Server) PingTest(client *resty.Client) error {
-	return s.pingTestContext(context.Background(), client)
+func (s *Server) PingTest(client Transport, opts ...TestOption) error {
+	return s.PingTestContext(context.Background(), client, opts...)
 }
 
-// pingTestContext executes test to measure latency, observing the given context.
-func (s *Server) pingTestContext(ctx context.Context, client *resty.Client) error {
-	pingURL := strings.Split(s.URL, "/upload.php")[0] + "/latency.txt"
+// PingTestContext executes test to measure latency, observing the given context.
+func (s *Server) PingTestContext(ctx context.Context, client Transport, opts ...TestOption) error {
+	settings := newTestSettings(opts...)
+	res, err := s.ping(ctx, client, settings, newEmitter(settings.Observer, s))
+	if err != nil {
+		return err
+	}
 
-	l := time.Duration(10000000000) // 10sec
-	for i := 0; i < 3; i++ {
-		sTime := time.Now()
+	s.Latency = res.Latency
+	return nil
+}
 
-		resp, err := client.R().
-			SetContext(ctx).
-			Get(pingURL)
+// ping measures latency without modifying s.
+func (s *Server) ping(ctx context.Context, client Transport, settings TestSettings, events *emitter) (PingResult, error) {
+	events.startPhase(PhasePing, 0)
+	sTime := time.Now()
+	b := s.newBackend(client, settings)
+	samples, err := b.pingSamples(ctx, settings, events)
+	if err != nil {
+		events.endPhase(Event{Err: err})
+		return PingResult{}, err
+	}
 
-		if err != nil {
-			return err
-		}
+	stats := newLatencyStats(samples)
+	// divide by 2 due to round trip time per request
+	latency := time.Duration(int64(stats.Min.Nanoseconds() / 2))
+	events.endPhase(Event{Latency: latency})
+
+	return PingResult{URL: b.endpoint(), Latency: latency, Stats: stats, Duration: time.Since(sTime)}, nil
+}
+
+// pingTestContext returns the round trip times of settings.PingCount requests to s.
+func (s *Server) pingTestContext(ctx context.Context, client Transport, settings TestSettings, events *emitter) ([]time.Duration, error) {
+	return s.newBackend(client, settings).pingSamples(ctx, settings, events)
+}
+
+// httpPingSamples returns the round trip times of settings.PingCount requests to pingURL.
+func httpPingSamples(ctx context.Context, client Transport, pingURL string, settings TestSettings, events *emitter) ([]time.Duration, error) {
+	count := settings.PingCount
+	if count < 1 {
+		count = 1
+	}
 
-		if resp.StatusCode() != 200 {
-			return fmt.Errorf("unexpected status code %v while pinging %v", resp.StatusCode(), pingURL)
+	samples := make([]time.Duration, 0, count)
+	for i := 0; i < count; i++ {
+		if i > 0 && settings.PingInterval > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(settings.PingInterval):
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, checkCancelled(ctx, "ping", err)
 		}
 
-		fTime := time.Now()
-		if fTime.Sub(sTime) < l {
-			l = fTime.Sub(sTime)
+		rtt, err := pingRequest(ctx, client, pingURL)
+		if err != nil {
+			return nil, checkCancelled(ctx, "ping", err)
 		}
+
+		events.emit(Event{Type: EventRequestDone, Latency: rtt})
+		samples = append(samples, rtt)
 	}
 
-	// divide by 2 due to round trip time per request
-	s.Latency = time.Duration(int64(l.Nanoseconds() / 2))
+	return samples, nil
+}
 
-	return nil
+// httpLatencyProbe returns a probe of pingURL on connections separate from those of client,
+// so that probes measure the latency of the loaded link rather than queue behind transfers.
+// The returned func releases the connections.
+func httpLatencyProbe(client Transport, pingURL string) (probeFunc, func()) {
+	probeClient, closeProbe := separateConnections(client)
+	probe := func(ctx context.Context) (time.Duration, error) {
+		return pingRequest(ctx, probeClient, pingURL)
+	}
+	return probe, closeProbe
+}
+
+// pingRequest returns the round trip time of a single request to pingURL.
+func pingRequest(ctx context.Context, client Transport, pingURL string) (time.Duration, error) {
+	sTime := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pingURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("unexpected status code %v while pinging %v", resp.StatusCode, pingURL)
+	}
+
+	return time.Since(sTime), nil
+}
+
+// counter accumulates the number of bytes transferred by concurrent requests.
+// Bytes are also added to the parent counter, if any.
+type counter struct {
+	n      int64
+	parent *counter
+}
+
+// Add adds n bytes to the counter.
+func (c *counter) Add(n int64) {
+	atomic.AddInt64(&c.n, n)
+	if c.parent != nil {
+		c.parent.Add(n)
+	}
+}
+
+// Write counts and discards p, so that a counter can be used as a sink for response bodies.
+func (c *counter) Write(p []byte) (int, error) {
+	c.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// Load returns the number of bytes counted so far.
+func (c *counter) Load() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// mbps converts n bytes transferred within d into megabits per second.
+func mbps(n int64, d time.Duration) float64 {
+	return float64(n) * 8.0 / 1000.0 / 1000.0 / d.Seconds()
+}
+
+// checkCancelled reports a cancellation error if ctx is done, otherwise err is returned as is.
+func checkCancelled(ctx context.Context, test string, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s test cancelled: %w", test, ctx.Err())
+	}
+	return err
 }
@@ -2,11 +2,17 @@ package speedtest
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
 )
@@ -18,17 +24,16 @@ func TestPingTestContext(t *testing.T) {
 		Latency: latency,
 	}
 
-	// Create a Resty Client
-	client := resty.New()
+	client := &http.Client{}
 
 	// fake response
 	resp := `test=test`
 
 	httpmock.Activate()
-	httpmock.ActivateNonDefault(client.GetClient())
+	httpmock.ActivateNonDefault(client)
 	httpmock.RegisterResponder("GET", "http://fake.com/latency.txt", fakeResponder(200, resp, "text/plain"))
 
-	err := server.pingTestContext(
+	err := server.PingTestContext(
 		context.Background(),
 		client,
 	)
@@ -36,22 +41,78 @@ func TestPingTestContext(t *testing.T) {
 	assert.Less(t, server.Latency.Milliseconds(), latency.Milliseconds(), "got unexpected server.Latency '%v', expected greater than 0", server.Latency)
 }
 
+func TestPingWithCountAndInterval(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	server := Server{
+		URL: "http://fake.com/upload.php",
+	}
+
+	client := &http.Client{}
+
+	httpmock.Activate()
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", "http://fake.com/latency.txt", fakeResponder(200, `test=test`, "text/plain"))
+
+	res, err := server.ping(
+		context.Background(),
+		client,
+		newTestSettings(WithPingCount(5), WithPingInterval(20*time.Millisecond)),
+		newEmitter(nil, &server),
+	)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 5, len(res.Stats.Samples))
+	assert.Equal(t, res.Stats.Min/2, res.Latency)
+	assert.GreaterOrEqual(t, int64(res.Stats.Max), int64(res.Stats.Median))
+	assert.GreaterOrEqual(t, res.Duration.Milliseconds(), int64(80), "samples were not taken at the interval")
+}
+
+func TestNewLatencyProbeUsesSeparateConnections(t *testing.T) {
+	conns := int32(0)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("test=test"))
+	}))
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	server := Server{
+		URL: ts.URL + "/upload.php",
+	}
+
+	client := &http.Client{}
+	_, err := pingRequest(context.Background(), client, ts.URL+"/latency.txt")
+	assert.NoError(t, err, "unexpected error %v", err)
+
+	probe, closeProbe := server.newBackend(client, TestSettings{}).latencyProbe()
+	defer closeProbe()
+	_, err = probe(context.Background())
+	assert.NoError(t, err, "unexpected error %v", err)
+	_, err = pingRequest(context.Background(), client, ts.URL+"/latency.txt")
+	assert.NoError(t, err, "unexpected error %v", err)
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&conns))
+}
+
 func TestPingTestContextWithStatus404(t *testing.T) {
 	server := Server{
 		URL: "http://fake.com/upload.php",
 	}
 
-	// Create a Resty Client
-	client := resty.New()
+	client := &http.Client{}
 
 	// fake response
 	resp := `test=test`
 
 	httpmock.Activate()
-	httpmock.ActivateNonDefault(client.GetClient())
+	httpmock.ActivateNonDefault(client)
 	httpmock.RegisterResponder("GET", "http://fake.com/latency.txt", fakeResponder(404, resp, "text/plain"))
 
-	err := server.pingTestContext(
+	err := server.PingTestContext(
 		context.Background(),
 		client,
 	)
@@ -66,18 +127,36 @@ func TestDownloadTestContext(t *testing.T) {
 		Latency: latency,
 	}
 
-	// Create a Resty Client
-	client := resty.New()
+	client := mockTransport(mockTransfer(100*time.Millisecond), mockTransfer(500*time.Millisecond))
 
 	err := server.downloadTestContext(
 		context.Background(),
 		client,
-		mockWarmUp,
-		mockRequest,
+		TestSettings{},
 	)
 	assert.NoError(t, err, "unexpected error %v", err)
 	assert.GreaterOrEqual(t, server.DLSpeed, 6300.0, "got unexpected server.DLSpeed '%v', expected between 6300 and 6600", server.DLSpeed)
 	assert.LessOrEqual(t, server.DLSpeed, 6600.0, "got unexpected server.DLSpeed '%v', expected between 6300 and 6600", server.DLSpeed)
+	// 2 warm up requests of 750x750 and 32 requests of 2500x2500
+	assert.Equal(t, int64(2*750*750*2+32*2500*2500*2), server.DLBytes)
+}
+
+func TestDownloadRequestCountsTransferredBytes(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	client := &http.Client{}
+
+	// the server returns a much smaller image than requested
+	resp := strings.Repeat("x", 1000)
+
+	httpmock.Activate()
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", "http://fake.com/random750x750.jpg", fakeResponder(200, resp, "image/jpeg"))
+
+	c := &counter{}
+	err := downloadRequest(context.Background(), client, "http://fake.com", 2, c)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, int64(1000), c.Load())
 }
 
 func TestDownloadTestContextWithStatus404(t *testing.T) {
@@ -87,23 +166,84 @@ func TestDownloadTestContextWithStatus404(t *testing.T) {
 		Latency: latency,
 	}
 
-	// Create a Resty Client
-	client := resty.New()
+	client := &http.Client{}
 
 	// fake response
 	resp := `fake-image`
 
 	httpmock.Activate()
-	httpmock.ActivateNonDefault(client.GetClient())
+	httpmock.ActivateNonDefault(client)
 	httpmock.RegisterResponder("GET", "http://fake.com/random750x750.jpg", fakeResponder(404, resp, "image/jpeg"))
 
 	err := server.downloadTestContext(
 		context.Background(),
 		client,
-		downloadRequest,
-		downloadRequest,
+		TestSettings{},
+	)
+	assert.Error(t, err, "should expect error")
+}
+
+func TestDownloadTestContextWithDuration(t *testing.T) {
+	server := Server{
+		URL: "http://fake.com/upload.php",
+	}
+
+	client := mockTransport(mockTransfer(100*time.Millisecond), mockStreamingRequest)
+
+	sTime := time.Now()
+	err := server.downloadTestContext(
+		context.Background(),
+		client,
+		newTestSettings(WithDuration(300*time.Millisecond), withoutLoadedLatency),
+	)
+	elapsed := time.Since(sTime)
+	assert.NoError(t, err, "unexpected error %v", err)
+	// 100ms warm up and 300ms main phase
+	assert.GreaterOrEqual(t, elapsed.Milliseconds(), int64(400), "main phase did not last its duration")
+	assert.Less(t, elapsed.Milliseconds(), int64(1000), "main phase was not stopped after its duration")
+	// 32 streams of 100 Mbps
+	assert.GreaterOrEqual(t, server.DLSpeed, 1600.0, "got unexpected server.DLSpeed '%v', expected between 1600 and 3300", server.DLSpeed)
+	assert.LessOrEqual(t, server.DLSpeed, 3300.0, "got unexpected server.DLSpeed '%v', expected between 1600 and 3300", server.DLSpeed)
+	// a sample every 100ms of warm up and main phase
+	assert.GreaterOrEqual(t, len(server.DLSamples), 4, "got unexpected server.DLSamples %v", server.DLSamples)
+	assert.Equal(t, server.DLBytes, server.DLSamples[len(server.DLSamples)-1].Bytes)
+}
+
+func TestDownloadTestContextWithStreams(t *testing.T) {
+	server := Server{
+		URL: "http://fake.com/upload.php",
+	}
+
+	client := mockTransport(mockTransfer(100*time.Millisecond), mockStreamingRequest)
+
+	err := server.downloadTestContext(
+		context.Background(),
+		client,
+		newTestSettings(WithDuration(300*time.Millisecond), WithDownloadStreams(4), withoutLoadedLatency),
+	)
+	assert.NoError(t, err, "unexpected error %v", err)
+	// 4 streams of 100 Mbps
+	assert.GreaterOrEqual(t, server.DLSpeed, 200.0, "got unexpected server.DLSpeed '%v', expected between 200 and 420", server.DLSpeed)
+	assert.LessOrEqual(t, server.DLSpeed, 420.0, "got unexpected server.DLSpeed '%v', expected between 200 and 420", server.DLSpeed)
+}
+
+func TestUploadTestContextWithDurationAndError(t *testing.T) {
+	server := Server{
+		URL: "http://fake.com/upload.php",
+	}
+
+	client := mockTransport(mockTransfer(100*time.Millisecond), func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection reset")
+	})
+
+	err := server.uploadTestContext(
+		context.Background(),
+		client,
+		newTestSettings(WithDuration(time.Second), withoutLoadedLatency),
 	)
 	assert.Error(t, err, "should expect error")
+	assert.Equal(t, "connection reset", err.Error())
+	assert.Equal(t, 0.0, server.ULSpeed)
 }
 
 func TestUploadTestContext(t *testing.T) {
@@ -113,18 +253,72 @@ func TestUploadTestContext(t *testing.T) {
 		Latency: latency,
 	}
 
-	// Create a Resty Client
-	client := resty.New()
+	client := mockTransport(mockTransfer(100*time.Millisecond), mockTransfer(500*time.Millisecond))
 
 	err := server.uploadTestContext(
 		context.Background(),
 		client,
-		mockWarmUp,
-		mockRequest,
+		TestSettings{},
 	)
 	assert.NoError(t, err, "unexpected error %v", err)
 	assert.GreaterOrEqual(t, server.ULSpeed, 2400.0, "got unexpected server.ULSpeed '%v', expected between 2400 and 2600", server.ULSpeed)
 	assert.LessOrEqual(t, server.ULSpeed, 2600.0, "got unexpected server.ULSpeed '%v', expected between 2400 and 2600", server.ULSpeed)
+	// 2 warm up requests of 1000kB and 40 requests of 4000kB
+	assert.Equal(t, int64(2*1000*1000+40*4000*1000), server.ULBytes)
+}
+
+func TestUploadTestContextWithDurationDrainsRequests(t *testing.T) {
+	server := Server{
+		URL: "http://fake.com/upload.php",
+	}
+
+	client := mockTransport(mockTransfer(100*time.Millisecond), mockTransfer(500*time.Millisecond))
+
+	err := server.uploadTestContext(
+		context.Background(),
+		client,
+		newTestSettings(WithDuration(300*time.Millisecond), withoutLoadedLatency),
+	)
+	assert.NoError(t, err, "unexpected error %v", err)
+	// requests in flight at the end of the window are acknowledged after it
+	assert.Equal(t, int64(2*1000*1000+40*4000*1000), server.ULBytes)
+	assert.GreaterOrEqual(t, server.ULSpeed, 2400.0, "got unexpected server.ULSpeed '%v', expected between 2400 and 2600", server.ULSpeed)
+	assert.LessOrEqual(t, server.ULSpeed, 2600.0, "got unexpected server.ULSpeed '%v', expected between 2400 and 2600", server.ULSpeed)
+}
+
+func TestUploadRequestCountsAcknowledgedBytes(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	client := &http.Client{}
+
+	httpmock.Activate()
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("POST", "http://fake.com/upload.php", func(req *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(req.Body)
+		assert.Equal(t, req.ContentLength, int64(len(body)))
+		assert.Equal(t, "application/x-www-form-urlencoded", req.Header.Get("Content-Type"))
+		// the server acknowledges less than it was sent
+		return httpmock.NewStringResponse(200, "size="+strconv.Itoa(len(body)/2)), err
+	})
+	httpmock.RegisterResponder("POST", "http://fake.com/empty.php", fakeResponder(200, "", "text/plain"))
+	httpmock.RegisterResponder("POST", "http://fake.com/reset.php", httpmock.NewErrorResponder(errors.New("connection reset")))
+
+	c := &counter{}
+	err := uploadRequest(context.Background(), client, "http://fake.com/upload.php", 0, c)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, int64(ulSizes[0]*1000/2), c.Load())
+
+	// replies of empty.php acknowledge the whole payload
+	c = &counter{}
+	err = uploadRequest(context.Background(), client, "http://fake.com/empty.php", 0, c)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, int64(ulSizes[0]*1000), c.Load())
+
+	// bytes of requests failing before a reply are not counted
+	c = &counter{}
+	err = uploadRequest(context.Background(), client, "http://fake.com/reset.php", 0, c)
+	assert.Error(t, err, "should expect error")
+	assert.Equal(t, int64(0), c.Load())
 }
 
 func TestUploadTestContextWithStatus404(t *testing.T) {
@@ -134,32 +328,213 @@ func TestUploadTestContextWithStatus404(t *testing.T) {
 		Latency: latency,
 	}
 
-	// Create a Resty Client
-	client := resty.New()
+	client := &http.Client{}
 
 	// fake response
 	resp := `fake-response`
 
 	httpmock.Activate()
-	httpmock.ActivateNonDefault(client.GetClient())
+	httpmock.ActivateNonDefault(client)
 	httpmock.RegisterResponder("Post", "http://fake.com/upload.php", fakeResponder(404, resp, "image/jpeg"))
 
 	err := server.uploadTestContext(
 		context.Background(),
 		client,
-		uploadRequest,
-		uploadRequest,
+		TestSettings{},
 	)
 	assert.Error(t, err, "should expect error")
 }
 
-func mockWarmUp(ctx context.Context, client *resty.Client, dlURL string, w int) error {
-	time.Sleep(100 * time.Millisecond)
-	return nil
+func TestPingTestContextCancelled(t *testing.T) {
+	server := Server{
+		URL: "http://fake.com/upload.php",
+	}
+
+	client := &http.Client{}
+
+	httpmock.Activate()
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", "http://fake.com/latency.txt", fakeResponder(200, `test=test`, "text/plain"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := server.PingTestContext(ctx, client)
+	assert.Error(t, err, "should expect error")
+	assert.True(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+	assert.Equal(t, time.Duration(0), server.Latency)
+}
+
+func TestDownloadTestContextCancelled(t *testing.T) {
+	server := Server{
+		URL: "http://fake.com/upload.php",
+	}
+
+	client := mockTransport(mockTransfer(100*time.Millisecond), mockStalledRequest)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	sTime := time.Now()
+	err := server.downloadTestContext(
+		ctx,
+		client,
+		TestSettings{},
+	)
+	assert.Error(t, err, "should expect error")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error %v", err)
+	assert.Equal(t, "download test cancelled: context deadline exceeded", err.Error())
+	assert.Less(t, time.Since(sTime).Milliseconds(), int64(1000), "test was not stopped promptly")
+	assert.Equal(t, 0.0, server.DLSpeed)
 }
 
-func mockRequest(ctx context.Context, client *resty.Client, dlURL string, w int) error {
-	fmt.Sprintln(w)
-	time.Sleep(500 * time.Millisecond)
+func TestUploadTestContextCancelled(t *testing.T) {
+	server := Server{
+		URL: "http://fake.com/upload.php",
+	}
+
+	client := mockTransport(mockTransfer(100*time.Millisecond), mockStalledRequest)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+
+	sTime := time.Now()
+	err := server.uploadTestContext(
+		ctx,
+		client,
+		TestSettings{},
+	)
+	assert.Error(t, err, "should expect error")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error %v", err)
+	assert.Less(t, time.Since(sTime).Milliseconds(), int64(1000), "test was not stopped promptly")
+	assert.Equal(t, 0.0, server.ULSpeed)
+}
+
+func BenchmarkDownloadRequest(b *testing.B) {
+	client := newBenchmarkClient()
+	defer httpmock.DeactivateAndReset()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := downloadRequest(context.Background(), client, "http://fake.com", 6, &counter{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDownloadRequestBuffered is the reference of reading whole bodies into memory.
+func BenchmarkDownloadRequestBuffered(b *testing.B) {
+	client := newBenchmarkClient()
+	defer httpmock.DeactivateAndReset()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := client.Get("http://fake.com/random2500x2500.jpg")
+		if err != nil {
+			b.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+		(&counter{}).Add(int64(len(body)))
+	}
+}
+
+// newBenchmarkClient returns a client serving random2500x2500.jpg from memory.
+func newBenchmarkClient() *http.Client {
+	client := &http.Client{}
+	image := make([]byte, 2500*2500*2)
+
+	httpmock.Activate()
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", "http://fake.com/random2500x2500.jpg", func(req *http.Request) (*http.Response, error) {
+		return httpmock.NewBytesResponse(200, image), nil
+	})
+
+	return client
+}
+
+// withoutLoadedLatency keeps tests of mocked transfers from probing latency, which the mocks do not answer.
+var withoutLoadedLatency = WithLoadedLatencyInterval(0)
+
+// mockTransport returns a Transport pretending to be a legacy server, answering the warm up
+// requests of the download and upload tests with warmUp and the others with request.
+func mockTransport(warmUp, request transportFunc) Transport {
+	return transportFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/random750x750.jpg" || req.ContentLength == 1000*1000 {
+			return warmUp(req)
+		}
+		return request(req)
+	})
+}
+
+// mockTransfer pretends to transfer the requested image or upload within d.
+func mockTransfer(d time.Duration) transportFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		if err := sleepContext(req.Context(), d); err != nil {
+			return nil, err
+		}
+		if req.Method == http.MethodPost {
+			// uploads are acknowledged unread, generating their payloads is slow with the race detector
+			return httpmock.NewStringResponse(200, "size="+strconv.FormatInt(req.ContentLength, 10)), nil
+		}
+		size, _ := strconv.Atoi(strings.SplitN(strings.TrimPrefix(req.URL.Path, "/random"), "x", 2)[0])
+		return &http.Response{StatusCode: 200, Body: &mockBody{n: int64(size * size * 2)}}, nil
+	}
+}
+
+// mockStalledRequest never answers before the request is cancelled.
+func mockStalledRequest(req *http.Request) (*http.Response, error) {
+	return nil, sleepContext(req.Context(), time.Hour)
+}
+
+// mockStreamingRequest answers with a body of 100 Mbps until the request is cancelled.
+func mockStreamingRequest(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: 200, Body: &mockBody{n: -1, ctx: req.Context()}}, nil
+}
+
+// mockBody is a response body of n bytes, or of 125000 bytes every 10ms until ctx is done
+// if n is negative. Its content is left as found in the buffers it is read into.
+type mockBody struct {
+	n       int64
+	ctx     context.Context
+	pending int64
+}
+
+func (b *mockBody) Read(p []byte) (int, error) {
+	if b.n < 0 && b.pending == 0 {
+		if err := sleepContext(b.ctx, 10*time.Millisecond); err != nil {
+			return 0, err
+		}
+		b.pending = 125000
+	}
+	left := &b.n
+	if b.n < 0 {
+		left = &b.pending
+	}
+	if *left == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > *left {
+		p = p[:*left]
+	}
+	*left -= int64(len(p))
+	return len(p), nil
+}
+
+func (b *mockBody) Close() error {
 	return nil
 }
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,257 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TestResult is the outcome of a full speed test against a server.
+// It holds copies rather than references to test state, so that it can be
+// stored and compared after the test ends.
+type TestResult struct {
+	// Server is a snapshot of the list metadata of the tested server.
+	Server Server    `json:"server"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	// Settings are those the test ran with, without the Observer.
+	Settings TestSettings `json:"settings"`
+	Ping     PingResult   `json:"ping"`
+	// UDP is the result of the UDP test, if one was run.
+	UDP      *UDPResult     `json:"udp,omitempty"`
+	Download TransferResult `json:"download"`
+	Upload   TransferResult `json:"upload"`
+	// Bufferbloat grades the largest increase of median latency under load.
+	// It is empty when no latency was probed under load.
+	Bufferbloat BufferbloatGrade `json:"bufferbloat,omitempty"`
+	// Failovers lists the failures the test recovered from by switching endpoint or server,
+	// or by skipping the UDP test.
+	Failovers []Failover `json:"failovers,omitempty"`
+}
+
+// Failover records a phase which failed on an endpoint.
+type Failover struct {
+	ServerID string `json:"server_id"`
+	URL      string `json:"url"`
+	Phase    Phase  `json:"phase"`
+	Err      string `json:"error"`
+}
+
+// PingResult is the outcome of a latency test.
+type PingResult struct {
+	// URL is the endpoint of the server the test ran against.
+	URL string `json:"url"`
+	// Latency is half of the lowest round trip time measured.
+	Latency  time.Duration `json:"latency"`
+	Stats    LatencyStats  `json:"stats"`
+	Duration time.Duration `json:"duration"`
+}
+
+// TransferResult is the outcome of a download or upload test.
+type TransferResult struct {
+	// URL is the endpoint of the server the test ran against.
+	URL string `json:"url"`
+	// Speed is the throughput of the main phase in Mbps.
+	Speed float64 `json:"speed"`
+	// Bytes is the number of bytes moved by warm up and main phase.
+	Bytes int64 `json:"bytes"`
+	// Duration includes warm up and main phase.
+	Duration       time.Duration `json:"duration"`
+	WarmUpDuration time.Duration `json:"warm_up_duration"`
+	Samples        []Sample      `json:"samples,omitempty"`
+	// Latency holds the round trip times probed while the main phase ran.
+	Latency LatencyStats `json:"latency"`
+}
+
+// Run executes ping, download and upload tests against s and returns their result.
+// Unlike PingTest, DownloadTest and UploadTest it does not modify s, so that one
+// Server can be tested repeatedly or concurrently.
+//
+// A phase which fails on the URL of s is run again on URL2, if any.
+func (s *Server) Run(client Transport, opts ...TestOption) (*TestResult, error) {
+	return s.RunContext(context.Background(), client, opts...)
+}
+
+// RunContext executes ping, download and upload tests against s and returns their result,
+// observing the given context.
+func (s *Server) RunContext(ctx context.Context, client Transport, opts ...TestOption) (*TestResult, error) {
+	res, err := s.run(ctx, client, newTestSettings(opts...))
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// RunWithFailover runs the tests of Run against the first server, and moves on to the
+// next one when a phase fails on both URL and URL2. Servers are expected to be ordered
+// by preference, such as by latency or distance.
+func (svrs Servers) RunWithFailover(client Transport, opts ...TestOption) (*TestResult, error) {
+	return svrs.RunWithFailoverContext(context.Background(), client, opts...)
+}
+
+// RunWithFailoverContext runs the tests of Run against the first server, and moves on to the
+// next one when a phase fails on both URL and URL2, observing the given context.
+func (svrs Servers) RunWithFailoverContext(ctx context.Context, client Transport, opts ...TestOption) (*TestResult, error) {
+	return svrs.runWithFailover(ctx, client, newTestSettings(opts...))
+}
+
+func (svrs Servers) runWithFailover(ctx context.Context, client Transport, settings TestSettings) (*TestResult, error) {
+	if len(svrs) == 0 {
+		return nil, errors.New("no servers available")
+	}
+
+	failovers := []Failover{}
+	var err error
+	for _, s := range svrs {
+		var res *TestResult
+		res, err = s.run(ctx, client, settings)
+		failovers = append(failovers, res.Failovers...)
+		if err == nil {
+			res.Failovers = failovers
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("all %d servers failed: %w", len(svrs), err)
+}
+
+// run executes the tests of Run. The result holds the failures recovered from even
+// when err is set.
+func (s *Server) run(ctx context.Context, client Transport, settings TestSettings) (*TestResult, error) {
+	events := newEmitter(settings.Observer, s)
+	res := &TestResult{
+		Server:   s.info(),
+		Start:    time.Now(),
+		Settings: settings,
+	}
+	// results do not hold on to the observer, which also keeps them comparable
+	res.Settings.Observer = nil
+
+	err := s.failover(ctx, res, PhasePing, func(e *Server) (err error) {
+		res.Ping, err = e.ping(ctx, client, settings, events)
+		return err
+	})
+	if err != nil {
+		return res, err
+	}
+	if settings.UDP != nil {
+		udp, err := s.udp(ctx, *settings.UDP, events)
+		switch {
+		case err == nil:
+			res.UDP = &udp
+		case ctx.Err() != nil:
+			return res, err
+		default:
+			// the other tests do not depend on the UDP test, which is skipped
+			url := "udp://" + s.udpEchoAddr(*settings.UDP)
+			res.Failovers = append(res.Failovers, Failover{ServerID: s.ID, URL: url, Phase: PhaseUDP, Err: err.Error()})
+		}
+	}
+	err = s.failover(ctx, res, PhaseDownload, func(e *Server) (err error) {
+		res.Download, err = e.download(ctx, client, settings, events, res.Ping.Latency)
+		return err
+	})
+	if err != nil {
+		return res, err
+	}
+	err = s.failover(ctx, res, PhaseUpload, func(e *Server) (err error) {
+		res.Upload, err = e.upload(ctx, client, settings, events, res.Ping.Latency)
+		return err
+	})
+	if err != nil {
+		return res, err
+	}
+
+	res.Bufferbloat = res.gradeBufferbloat()
+	res.End = time.Now()
+	return res, nil
+}
+
+// failover runs a phase against s, and again against URL2 of s if it fails.
+// Failures are recorded in res.
+func (s *Server) failover(ctx context.Context, res *TestResult, phase Phase, run func(*Server) error) error {
+	endpoints := []string{s.URL}
+	if s.URL2 != "" && s.URL2 != s.URL {
+		endpoints = append(endpoints, s.URL2)
+	}
+
+	var err error
+	for _, url := range endpoints {
+		e := s
+		if url != s.URL {
+			alt := *s
+			alt.URL = url
+			e = &alt
+		}
+		if err = run(e); err == nil {
+			return nil
+		}
+		res.Failovers = append(res.Failovers, Failover{ServerID: s.ID, URL: url, Phase: phase, Err: err.Error()})
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// LatencyIncrease returns the largest increase of median latency under load
+// compared to idle latency, and whether latency was probed under load.
+func (r *TestResult) LatencyIncrease() (time.Duration, bool) {
+	increase := time.Duration(0)
+	probed := false
+	for _, loaded := range []LatencyStats{r.Download.Latency, r.Upload.Latency} {
+		if len(loaded.Samples) == 0 {
+			continue
+		}
+		probed = true
+		if d := loaded.Median - r.Ping.Stats.Median; d > increase {
+			increase = d
+		}
+	}
+	return increase, probed
+}
+
+func (r *TestResult) gradeBufferbloat() BufferbloatGrade {
+	increase, probed := r.LatencyIncrease()
+	if !probed {
+		return ""
+	}
+	return gradeBufferbloat(increase)
+}
+
+// CheckResultValid checks that results are logical given UL and DL speeds
+func (r *TestResult) CheckResultValid() bool {
+	return validSpeeds(r.Download.Speed, r.Upload.Speed)
+}
+
+// validSpeeds reports whether neither of download and upload speeds is over 100 times the other.
+func validSpeeds(dl, ul float64) bool {
+	return !(dl*100 < ul || dl > ul*100)
+}
+
+// info returns a copy of the list metadata of s, without measurements.
+func (s *Server) info() Server {
+	var libreSpeed *LibreSpeed
+	if s.LibreSpeed != nil {
+		ls := *s.LibreSpeed
+		libreSpeed = &ls
+	}
+	return Server{
+		URL:        s.URL,
+		Lat:        s.Lat,
+		Lon:        s.Lon,
+		Name:       s.Name,
+		Country:    s.Country,
+		CC:         s.CC,
+		Sponsor:    s.Sponsor,
+		ID:         s.ID,
+		URL2:       s.URL2,
+		Host:       s.Host,
+		Distance:   s.Distance,
+		LibreSpeed: libreSpeed,
+	}
+}
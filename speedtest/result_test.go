@@ -0,0 +1,265 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sync/errgroup"
+)
+
+// newFakeServer registers responders of a server at http://fake.com returning
+// images of 1000 bytes, and returns a client using them.
+func newFakeServer() *http.Client {
+	client := &http.Client{}
+
+	httpmock.Activate()
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", "http://fake.com/latency.txt", fakeResponder(200, `test=test`, "text/plain"))
+	httpmock.RegisterResponder("GET", `=~^http://fake\.com/random\d+x\d+\.jpg`, fakeResponder(200, strings.Repeat("x", 1000), "image/jpeg"))
+	httpmock.RegisterResponder("POST", "http://fake.com/upload.php", func(req *http.Request) (*http.Response, error) {
+		// uploads are acknowledged unread, generating their payloads is slow with the race detector
+		return httpmock.NewStringResponse(200, "size="+strconv.FormatInt(req.ContentLength, 10)), nil
+	})
+
+	return client
+}
+
+func TestRun(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	client := newFakeServer()
+
+	server := Server{
+		URL: "http://fake.com/upload.php",
+		ID:  "1",
+	}
+
+	res, err := server.Run(client, WithDuration(100*time.Millisecond))
+	assert.NoError(t, err, "unexpected error %v", err)
+
+	assert.Equal(t, "1", res.Server.ID)
+	assert.Equal(t, "http://fake.com/upload.php", res.Server.URL)
+	assert.True(t, res.Start.Before(res.End))
+	assert.Equal(t, 100*time.Millisecond, res.Settings.DownloadDuration)
+	assert.Greater(t, int64(res.Ping.Latency), int64(0))
+	assert.Greater(t, int64(res.Ping.Duration), int64(0))
+
+	assert.Greater(t, res.Download.Speed, 0.0)
+	assert.Greater(t, res.Download.Bytes, int64(2000))
+	assert.Equal(t, int64(0), res.Download.Bytes%1000)
+	assert.GreaterOrEqual(t, int64(res.Download.Duration), int64(res.Download.WarmUpDuration+100*time.Millisecond))
+	assert.Greater(t, res.Upload.Speed, 0.0)
+	assert.Greater(t, res.Upload.Bytes, int64(2*1000*1000))
+
+	// latency is probed while download and upload run
+	assert.Greater(t, len(res.Download.Latency.Samples), 0)
+	assert.Greater(t, len(res.Upload.Latency.Samples), 0)
+	assert.NotEqual(t, BufferbloatGrade(""), res.Bufferbloat)
+
+	// Run does not modify the server
+	assert.Equal(t, Server{URL: "http://fake.com/upload.php", ID: "1"}, server)
+}
+
+// registerBrokenUpload registers responders of a server at http://broken.com which pings
+// and serves images, but fails uploads.
+func registerBrokenUpload() {
+	httpmock.RegisterResponder("GET", "http://broken.com/latency.txt", fakeResponder(200, `test=test`, "text/plain"))
+	httpmock.RegisterResponder("GET", `=~^http://broken\.com/random\d+x\d+\.jpg`, fakeResponder(200, strings.Repeat("x", 1000), "image/jpeg"))
+	httpmock.RegisterResponder("POST", "http://broken.com/upload.php", fakeResponder(500, "", "text/plain"))
+}
+
+func TestRunFailsOverToURL2(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	client := newFakeServer()
+	registerBrokenUpload()
+
+	server := Server{
+		URL:  "http://broken.com/upload.php",
+		URL2: "http://fake.com/upload.php",
+		ID:   "1",
+	}
+
+	res, err := server.Run(client, WithDuration(50*time.Millisecond), withoutLoadedLatency)
+	assert.NoError(t, err, "unexpected error %v", err)
+
+	assert.Equal(t, "http://broken.com/upload.php", res.Ping.URL)
+	assert.Equal(t, "http://broken.com/upload.php", res.Download.URL)
+	assert.Equal(t, "http://fake.com/upload.php", res.Upload.URL)
+	assert.Greater(t, res.Upload.Speed, 0.0)
+	assert.Equal(t, 1, len(res.Failovers))
+	assert.Equal(t, Failover{
+		ServerID: "1",
+		URL:      "http://broken.com/upload.php",
+		Phase:    PhaseUpload,
+		Err:      "unexpected status code 500 while uploading to http://broken.com/upload.php",
+	}, res.Failovers[0])
+
+	server.URL2 = ""
+	_, err = server.Run(client, WithDuration(50*time.Millisecond), withoutLoadedLatency)
+	assert.Error(t, err, "should expect error without URL2")
+}
+
+func TestRunWithFailover(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	client := newFakeServer()
+	registerBrokenUpload()
+
+	servers := Servers{
+		{URL: "http://broken.com/upload.php", URL2: "http://broken.com/upload.php", ID: "1"},
+		{URL: "http://unreachable.com/upload.php", ID: "2"},
+		{URL: "http://fake.com/upload.php", ID: "3"},
+		{URL: "http://broken.com/upload.php", ID: "4"},
+	}
+
+	res, err := servers.RunWithFailover(client, WithDuration(50*time.Millisecond), withoutLoadedLatency)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "3", res.Server.ID)
+	assert.Equal(t, "http://fake.com/upload.php", res.Upload.URL)
+	assert.Equal(t, 2, len(res.Failovers), "URL2 equal to URL should not be tried")
+	assert.Equal(t, "1", res.Failovers[0].ServerID)
+	assert.Equal(t, PhaseUpload, res.Failovers[0].Phase)
+	assert.Equal(t, "2", res.Failovers[1].ServerID)
+	assert.Equal(t, PhasePing, res.Failovers[1].Phase)
+
+	_, err = servers[:2].RunWithFailover(client, WithDuration(50*time.Millisecond), withoutLoadedLatency)
+	assert.Error(t, err, "should expect error when all servers fail")
+
+	_, err = Servers{}.RunWithFailover(client)
+	assert.Error(t, err)
+}
+
+func TestRunWithFailoverCancelled(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	client := newFakeServer()
+
+	servers := Servers{
+		{URL: "http://fake.com/upload.php", ID: "1"},
+		{URL: "http://fake.com/upload.php", ID: "2"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := servers.RunWithFailoverContext(ctx, client)
+	assert.True(t, errors.Is(err, context.Canceled), "unexpected error %v", err)
+}
+
+func TestRunConcurrently(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	client := newFakeServer()
+
+	server := Server{
+		URL: "http://fake.com/upload.php",
+	}
+
+	results := make([]*TestResult, 2)
+	eg := errgroup.Group{}
+	for i := range results {
+		i := i
+		eg.Go(func() error {
+			res, err := server.Run(client, WithDuration(100*time.Millisecond))
+			results[i] = res
+			return err
+		})
+	}
+	assert.NoError(t, eg.Wait())
+	assert.NotEqual(t, results[0].Start, results[1].Start)
+	assert.Greater(t, results[0].Download.Bytes, int64(0))
+	assert.Greater(t, results[1].Download.Bytes, int64(0))
+}
+
+func TestRunContextCancelled(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	client := newFakeServer()
+
+	server := Server{
+		URL: "http://fake.com/upload.php",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	res, err := server.RunContext(ctx, client)
+	assert.Error(t, err, "should expect error")
+	assert.Nil(t, res)
+}
+
+func TestRunWithoutLoadedLatency(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	client := newFakeServer()
+
+	server := Server{
+		URL: "http://fake.com/upload.php",
+	}
+
+	res, err := server.Run(client, WithDuration(100*time.Millisecond), WithLoadedLatencyInterval(0))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 0, len(res.Download.Latency.Samples))
+	assert.Equal(t, BufferbloatGrade(""), res.Bufferbloat)
+}
+
+func TestRunResultIsComparable(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	client := newFakeServer()
+	httpmock.RegisterResponder("GET", "http://fake.com/backend/empty.php", fakeResponder(200, "", "text/plain"))
+	httpmock.RegisterResponder("GET", "http://fake.com/backend/garbage.php", fakeResponder(200, strings.Repeat("x", 1000), "application/octet-stream"))
+	httpmock.RegisterResponder("POST", "http://fake.com/backend/empty.php", fakeResponder(200, "", "text/plain"))
+
+	server := Server{
+		URL:  "http://fake.com/backend/",
+		ID:   "1",
+		Name: "fake",
+		LibreSpeed: &LibreSpeed{
+			Download: "http://fake.com/backend/garbage.php",
+			Upload:   "http://fake.com/backend/empty.php",
+			Ping:     "http://fake.com/backend/empty.php",
+		},
+	}
+
+	events := 0
+	res, err := server.Run(client, WithDuration(100*time.Millisecond), WithObserver(func(Event) { events++ }))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Greater(t, events, 0)
+	assert.Nil(t, res.Settings.Observer)
+
+	stored := *res
+	libreSpeed := *res.Server.LibreSpeed
+	stored.Server.LibreSpeed = &libreSpeed
+
+	// the result does not change along with the server it was taken from
+	server.ID = "2"
+	server.Name = "changed"
+	server.LibreSpeed.Download = "http://changed.com/garbage.php"
+	server.DLSpeed = 1
+	assert.Equal(t, stored, *res)
+	assert.Equal(t, "http://fake.com/backend/garbage.php", res.Server.LibreSpeed.Download)
+}
+
+func TestTestResultLatencyIncrease(t *testing.T) {
+	ms := time.Millisecond
+	res := TestResult{
+		Ping:     PingResult{Stats: newLatencyStats([]time.Duration{10 * ms})},
+		Download: TransferResult{Latency: newLatencyStats([]time.Duration{50 * ms, 60 * ms, 70 * ms})},
+		Upload:   TransferResult{Latency: newLatencyStats([]time.Duration{20 * ms})},
+	}
+	increase, probed := res.LatencyIncrease()
+	assert.True(t, probed)
+	assert.Equal(t, 50*ms, increase)
+	assert.Equal(t, GradeB, res.gradeBufferbloat())
+}
+
+func TestTestResultCheckResultValid(t *testing.T) {
+	res := TestResult{Download: TransferResult{Speed: 100}, Upload: TransferResult{Speed: 10}}
+	assert.True(t, res.CheckResultValid())
+
+	res = TestResult{Download: TransferResult{Speed: 1000}, Upload: TransferResult{Speed: 1}}
+	assert.False(t, res.CheckResultValid())
+
+	res = TestResult{Download: TransferResult{Speed: 1}, Upload: TransferResult{Speed: 1000}}
+	assert.False(t, res.CheckResultValid())
+}
@@ -0,0 +1,117 @@
+package speedtest
+
+import (
+	"sync"
+	"time"
+)
+
+// Sample is the throughput measured over one sampling interval of a test.
+type Sample struct {
+	// Elapsed is the time since the start of the test at the end of the interval.
+	Elapsed time.Duration `json:"elapsed"`
+	// Bytes is the total number of bytes moved since the start of the test.
+	Bytes int64 `json:"bytes"`
+	// Speed is the throughput within the interval in Mbps.
+	Speed float64 `json:"speed"`
+}
+
+// sampler records the throughput of a set of counters at a fixed interval.
+type sampler struct {
+	counters []*counter
+	interval time.Duration
+	onSample func(Sample)
+	start    time.Time
+	samples  []Sample
+	mu       sync.Mutex
+	done     chan struct{}
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// startSampler starts sampling the sum of counters every interval, passing every sample
+// taken to onSample if set. A zero interval records no samples.
+func startSampler(interval time.Duration, onSample func(Sample), counters ...*counter) *sampler {
+	s := &sampler{
+		counters: counters,
+		interval: interval,
+		onSample: onSample,
+		start:    time.Now(),
+		done:     make(chan struct{}),
+	}
+	if interval <= 0 {
+		return s
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				sample := s.record()
+				if s.onSample != nil {
+					s.onSample(sample)
+				}
+			}
+		}
+	}()
+	return s
+}
+
+// record takes a sample of the interval since the previous one.
+func (s *sampler) record() Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := Sample{}
+	if len(s.samples) > 0 {
+		prev = s.samples[len(s.samples)-1]
+	}
+	sample := s.sample(prev)
+	s.samples = append(s.samples, sample)
+	return sample
+}
+
+// sample measures the counters against prev.
+func (s *sampler) sample(prev Sample) Sample {
+	n := int64(0)
+	for _, c := range s.counters {
+		n += c.Load()
+	}
+	sample := Sample{Elapsed: time.Since(s.start), Bytes: n}
+	sample.Speed = mbps(sample.Bytes-prev.Bytes, sample.Elapsed-prev.Elapsed)
+	return sample
+}
+
+// Stop stops sampling and returns the samples recorded. The time since the last tick
+// is recorded as a final, shorter sample, or merged into the last one if it just happened.
+// Later calls return the same samples.
+func (s *sampler) Stop() []Sample {
+	s.stopOnce.Do(s.stop)
+	return s.samples
+}
+
+func (s *sampler) stop() {
+	close(s.done)
+	s.wg.Wait()
+
+	if s.interval <= 0 {
+		return
+	}
+
+	last := len(s.samples) - 1
+	if last >= 0 && time.Since(s.start)-s.samples[last].Elapsed < time.Millisecond {
+		prev := Sample{}
+		if last > 0 {
+			prev = s.samples[last-1]
+		}
+		s.samples[last] = s.sample(prev)
+		return
+	}
+
+	s.record()
+}
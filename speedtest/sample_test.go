@@ -0,0 +1,56 @@
+package speedtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampler(t *testing.T) {
+	c1, c2 := &counter{}, &counter{}
+	s := startSampler(20*time.Millisecond, nil, c1, c2)
+
+	// 1 Mb every 10ms, 100 Mbps in total
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			time.Sleep(10 * time.Millisecond)
+			if i < 5 {
+				c1.Add(62500)
+			} else {
+				c2.Add(62500)
+			}
+		}
+		close(done)
+	}()
+	<-done
+
+	samples := s.Stop()
+	assert.GreaterOrEqual(t, len(samples), 3, "got unexpected samples %v", samples)
+
+	last := samples[len(samples)-1]
+	assert.Equal(t, int64(625000), last.Bytes)
+	for i := 1; i < len(samples); i++ {
+		assert.Greater(t, int64(samples[i].Elapsed), int64(samples[i-1].Elapsed))
+		assert.GreaterOrEqual(t, samples[i].Bytes, samples[i-1].Bytes)
+	}
+
+	total := 0.0
+	for i, smp := range samples {
+		prev := time.Duration(0)
+		if i > 0 {
+			prev = samples[i-1].Elapsed
+		}
+		total += smp.Speed * (smp.Elapsed - prev).Seconds()
+	}
+	assert.InDelta(t, 5.0, total, 0.01, "samples should add up to the megabits moved")
+}
+
+func TestSamplerDisabled(t *testing.T) {
+	c := &counter{}
+	s := startSampler(0, nil, c)
+	c.Add(1000)
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, s.Stop())
+}
@@ -0,0 +1,150 @@
+package speedtest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SelectSettings holds the parameters the best server is selected with.
+type SelectSettings struct {
+	// Candidates is the number of nearest servers probed.
+	Candidates int `json:"candidates"`
+	// Timeout bounds the time all candidates are probed in.
+	Timeout time.Duration `json:"timeout"`
+	// PingCount is the number of latency samples taken from every candidate.
+	PingCount int `json:"ping_count"`
+	// Protocol is how candidates are probed, that of the tests to run on the best one.
+	Protocol Protocol `json:"protocol"`
+}
+
+// SelectOption configures SelectSettings.
+type SelectOption func(*SelectSettings)
+
+// WithCandidates probes the n nearest servers.
+func WithCandidates(n int) SelectOption {
+	return func(s *SelectSettings) {
+		s.Candidates = n
+	}
+}
+
+// WithProbeTimeout gives up on candidates which did not answer within d.
+func WithProbeTimeout(d time.Duration) SelectOption {
+	return func(s *SelectSettings) {
+		s.Timeout = d
+	}
+}
+
+// WithProbePingCount takes n latency samples from every candidate.
+func WithProbePingCount(n int) SelectOption {
+	return func(s *SelectSettings) {
+		s.PingCount = n
+	}
+}
+
+// WithProbeProtocol probes candidates over the given protocol, which should be that of the tests.
+func WithProbeProtocol(p Protocol) SelectOption {
+	return func(s *SelectSettings) {
+		s.Protocol = p
+	}
+}
+
+func newSelectSettings(opts ...SelectOption) SelectSettings {
+	s := SelectSettings{
+		Candidates: 5,
+		Timeout:    2 * time.Second,
+		PingCount:  2,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
+}
+
+// Probe is the latency of a candidate measured while selecting the best server.
+type Probe struct {
+	Server *Server `json:"server"`
+	// Latency is the lowest round trip time measured.
+	Latency time.Duration `json:"latency"`
+	// Err is set when the candidate did not answer in time.
+	Err error `json:"-"`
+}
+
+// Probes for sorting probes, answering candidates first and by latency.
+type Probes []Probe
+
+// Len finds length of probes. For sorting probes.
+func (p Probes) Len() int {
+	return len(p)
+}
+
+// Swap swaps i-th and j-th. For sorting probes.
+func (p Probes) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
+
+// Less compares the latency, failed probes sort last. For sorting probes.
+func (p Probes) Less(i, j int) bool {
+	if (p[i].Err == nil) != (p[j].Err == nil) {
+		return p[i].Err == nil
+	}
+	return p[i].Latency < p[j].Latency
+}
+
+// BestServer pings the nearest servers concurrently and returns the one with the lowest latency,
+// along with the probes of all candidates.
+func (l *ServerList) BestServer(client Transport, opts ...SelectOption) (*Server, Probes, error) {
+	return l.BestServerContext(context.Background(), client, opts...)
+}
+
+// BestServerContext pings the nearest servers concurrently and returns the one with the lowest latency,
+// along with the probes of all candidates, observing the given context.
+func (l *ServerList) BestServerContext(ctx context.Context, client Transport, opts ...SelectOption) (*Server, Probes, error) {
+	return l.bestServer(ctx, client, newSelectSettings(opts...))
+}
+
+func (l *ServerList) bestServer(ctx context.Context, client Transport, settings SelectSettings) (*Server, Probes, error) {
+	if len(l.Servers) <= 0 {
+		return nil, nil, fmt.Errorf("no servers available")
+	}
+
+	candidates := l.Servers
+	if settings.Candidates > 0 && settings.Candidates < len(candidates) {
+		candidates = candidates[:settings.Candidates]
+	}
+
+	if settings.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, settings.Timeout)
+		defer cancel()
+	}
+
+	probes := make(Probes, len(candidates))
+	var wg sync.WaitGroup
+	for i, s := range candidates {
+		wg.Add(1)
+		go func(i int, s *Server) {
+			defer wg.Done()
+			probes[i] = s.probe(ctx, client, settings)
+		}(i, s)
+	}
+	wg.Wait()
+
+	sort.Stable(probes)
+	if probes[0].Err != nil {
+		return nil, probes, fmt.Errorf("none of %d candidates answered: %w", len(probes), probes[0].Err)
+	}
+
+	return probes[0].Server, probes, nil
+}
+
+// probe measures the lowest round trip time of settings.PingCount requests to s.
+func (s *Server) probe(ctx context.Context, client Transport, settings SelectSettings) Probe {
+	samples, err := s.pingTestContext(ctx, client, TestSettings{PingCount: settings.PingCount, Protocol: settings.Protocol}, newEmitter(nil, s))
+	if err != nil {
+		return Probe{Server: s, Err: err}
+	}
+	return Probe{Server: s, Latency: newLatencyStats(samples).Min}
+}
@@ -0,0 +1,104 @@
+package speedtest
+
+import (
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// delayedResponder answers latency requests after d, or fails when the request is cancelled first.
+func delayedResponder(d time.Duration) httpmock.Responder {
+	return func(req *http.Request) (*http.Response, error) {
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(d):
+		}
+		return httpmock.NewStringResponse(200, "test=test"), nil
+	}
+}
+
+func newSelectList() ServerList {
+	return ServerList{Servers: []*Server{
+		{ID: "1", URL: "http://near.com/upload.php", Distance: 1},
+		{ID: "2", URL: "http://fast.com/upload.php", Distance: 2},
+		{ID: "3", URL: "http://stalled.com/upload.php", Distance: 3},
+		{ID: "4", URL: "http://far.com/upload.php", Distance: 4},
+	}}
+}
+
+func TestBestServer(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterResponder("GET", "http://near.com/latency.txt", delayedResponder(40*time.Millisecond))
+	httpmock.RegisterResponder("GET", "http://fast.com/latency.txt", delayedResponder(5*time.Millisecond))
+	httpmock.RegisterResponder("GET", "http://stalled.com/latency.txt", delayedResponder(time.Minute))
+	httpmock.RegisterResponder("GET", "http://far.com/latency.txt", delayedResponder(time.Millisecond))
+
+	list := newSelectList()
+	sTime := time.Now()
+	best, probes, err := list.BestServer(client, WithCandidates(3), WithProbeTimeout(300*time.Millisecond))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Less(t, int64(time.Since(sTime)), int64(time.Second), "stalled candidate should time out")
+
+	assert.Equal(t, "2", best.ID)
+	assert.Equal(t, 3, len(probes), "only the nearest servers should be probed")
+	assert.Equal(t, "2", probes[0].Server.ID)
+	assert.Equal(t, "1", probes[1].Server.ID)
+	assert.Equal(t, "3", probes[2].Server.ID)
+	assert.NoError(t, probes[0].Err)
+	assert.Error(t, probes[2].Err)
+	assert.GreaterOrEqual(t, int64(probes[0].Latency), int64(5*time.Millisecond))
+	assert.Equal(t, 0, httpmock.GetCallCountInfo()["GET http://far.com/latency.txt"])
+}
+
+func TestBestServerWithoutAnswer(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+
+	client := &http.Client{}
+	httpmock.ActivateNonDefault(client)
+	httpmock.RegisterNoResponder(delayedResponder(time.Minute))
+
+	list := newSelectList()
+	best, probes, err := list.BestServer(client, WithProbeTimeout(50*time.Millisecond))
+	assert.Error(t, err)
+	assert.Nil(t, best)
+	assert.Equal(t, 4, len(probes))
+}
+
+func TestBestServerWithEmptyList(t *testing.T) {
+	list := ServerList{}
+	_, _, err := list.BestServer(&http.Client{})
+	assert.Error(t, err)
+}
+
+func TestProbesSort(t *testing.T) {
+	probes := Probes{
+		{Latency: 0, Err: assert.AnError},
+		{Latency: 20 * time.Millisecond},
+		{Latency: 10 * time.Millisecond},
+	}
+	sort.Sort(probes)
+	assert.Equal(t, 10*time.Millisecond, probes[0].Latency)
+	assert.Equal(t, 20*time.Millisecond, probes[1].Latency)
+	assert.Error(t, probes[2].Err)
+}
+
+func TestNewSelectSettings(t *testing.T) {
+	s := newSelectSettings()
+	assert.Equal(t, 5, s.Candidates)
+	assert.Equal(t, 2*time.Second, s.Timeout)
+	assert.Equal(t, 2, s.PingCount)
+
+	s = newSelectSettings(WithCandidates(10), WithProbeTimeout(time.Second), WithProbePingCount(1), WithProbeProtocol(ProtocolTCP))
+	assert.Equal(t, 10, s.Candidates)
+	assert.Equal(t, time.Second, s.Timeout)
+	assert.Equal(t, 1, s.PingCount)
+	assert.Equal(t, ProtocolTCP, s.Protocol)
+}
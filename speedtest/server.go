@@ -1,39 +1,53 @@
 package speedtest
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"math"
+	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
-
-	"github.com/go-resty/resty/v2"
 )
 
 const speedTestServersUrl = "https://www2.speedtest.net/speedtest-servers-static.php"
 
 // Server information
+//
+// Latency, speeds, bytes and samples are measurements set by PingTest,
+// DownloadTest and UploadTest. Use Run to get them as a TestResult instead.
 type Server struct {
-	URL      string        `xml:"url,attr" json:"url"`
-	Lat      string        `xml:"lat,attr" json:"lat"`
-	Lon      string        `xml:"lon,attr" json:"lon"`
-	Name     string        `xml:"name,attr" json:"name"`
-	Country  string        `xml:"country,attr" json:"country"`
-	Sponsor  string        `xml:"sponsor,attr" json:"sponsor"`
-	ID       string        `xml:"id,attr" json:"id"`
-	URL2     string        `xml:"url2,attr" json:"url_2"`
-	Host     string        `xml:"host,attr" json:"host"`
-	Distance float64       `json:"distance"`
-	Latency  time.Duration `json:"latency"`
-	DLSpeed  float64       `json:"dl_speed"`
-	ULSpeed  float64       `json:"ul_speed"`
+	URL     string `xml:"url,attr" json:"url"`
+	Lat     string `xml:"lat,attr" json:"lat"`
+	Lon     string `xml:"lon,attr" json:"lon"`
+	Name    string `xml:"name,attr" json:"name"`
+	Country string `xml:"country,attr" json:"country"`
+	CC      string `xml:"cc,attr" json:"cc"`
+	Sponsor string `xml:"sponsor,attr" json:"sponsor"`
+	ID      string `xml:"id,attr" json:"id"`
+	URL2    string `xml:"url2,attr" json:"url_2"`
+	Host    string `xml:"host,attr" json:"host"`
+	// LibreSpeed holds the endpoints of servers of LibreSpeed lists, which are tested
+	// over the protocol of LibreSpeed. It is nil for servers of speedtest.net.
+	LibreSpeed *LibreSpeed   `xml:"-" json:"librespeed,omitempty"`
+	Distance   float64       `json:"distance"`
+	Latency    time.Duration `json:"latency"`
+	DLSpeed    float64       `json:"dl_speed"`
+	ULSpeed    float64       `json:"ul_speed"`
+	DLBytes    int64         `json:"dl_bytes,omitempty"`
+	ULBytes    int64         `json:"ul_bytes,omitempty"`
+	DLSamples  []Sample      `json:"dl_samples,omitempty"`
+	ULSamples  []Sample      `json:"ul_samples,omitempty"`
 }
 
 // ServerList list of Server
 type ServerList struct {
-	Servers []*Server `xml:"servers>server"`
+	Servers []*Server `xml:"servers>server" json:"servers"`
 }
 
 // Servers for sorting servers.
@@ -59,46 +73,144 @@ func (b ByDistance) Less(i, j int) bool {
 	return b.Servers[i].Distance < b.Servers[j].Distance
 }
 
-// FetchServerList retrieves a list of available servers
-func FetchServerList(client *resty.Client, user *User) (ServerList, error) {
-	return FetchServerListContext(context.Background(), client, user)
+// FetchServerList retrieves a list of available servers, sorted by distance to user.
+// The user may be nil when an origin is given by opts, see WithOrigin and WithoutOrigin.
+func FetchServerList(client Transport, user *User, opts ...FetchOption) (ServerList, error) {
+	return FetchServerListContext(context.Background(), client, user, opts...)
 }
 
 // FetchServerListContext retrieves a list of available servers, observing the given context.
-func FetchServerListContext(ctx context.Context, client *resty.Client, user *User) (ServerList, error) {
-	list := ServerList{}
+func FetchServerListContext(ctx context.Context, client Transport, user *User, opts ...FetchOption) (ServerList, error) {
+	return fetchServerList(ctx, client, user, opts...)
+}
 
-	resp, err := client.R().
-		SetContext(ctx).
-		SetResult(&list).
-		Get(speedTestServersUrl)
+func fetchServerList(ctx context.Context, client Transport, user *User, opts ...FetchOption) (ServerList, error) {
+	list := ServerList{}
+	settings := newFetchSettings(opts...)
 
+	err := fetch(ctx, client, settings.ServerListURL, "retrieving server list", settings, func(data []byte) error {
+		var err error
+		if list, err = decodeServerList(data); err != nil {
+			return err
+		}
+		if len(list.Servers) <= 0 {
+			return fmt.Errorf("unable to retrieve server list from %v", settings.ServerListURL)
+		}
+		return nil
+	})
 	if err != nil {
 		return list, err
 	}
 
-	if resp.StatusCode() != 200 {
-		return list, fmt.Errorf("unexpected status code %v while retrieving server list from %v", resp.StatusCode(), speedTestServersUrl)
+	list.dropIgnored(settings)
+	list.sortByOrigin(user, settings)
+	return list, nil
+}
+
+// LoadServerList reads a list of servers from an XML or JSON file with the schema of ServerList,
+// or from the JSON array of a LibreSpeed server list.
+// Servers are sorted by distance to user, if given, or to the origin given by opts.
+func LoadServerList(path string, user *User, opts ...FetchOption) (ServerList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ServerList{}, err
 	}
 
-	// Calculate distance
-	for i := range list.Servers {
-		server := list.Servers[i]
-		sLat, _ := strconv.ParseFloat(server.Lat, 64)
-		sLon, _ := strconv.ParseFloat(server.Lon, 64)
+	list, err := decodeServerList(data)
+	if err != nil {
+		return ServerList{}, fmt.Errorf("unable to read server list from %v: %w", path, err)
+	}
+	if len(list.Servers) <= 0 {
+		return list, fmt.Errorf("unable to read server list from %v", path)
+	}
+
+	settings := newFetchSettings(opts...)
+	list.dropIgnored(settings)
+	list.sortByOrigin(user, settings)
+	return list, nil
+}
+
+// decodeServerList decodes a JSON or XML document with the schema of ServerList, or a LibreSpeed
+// server list.
+func decodeServerList(data []byte) (ServerList, error) {
+	list := ServerList{}
+	var err error
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		return decodeLibreSpeedServerList(data)
+	case len(trimmed) > 0 && trimmed[0] == '{':
+		err = json.Unmarshal(data, &list)
+	default:
+		err = xml.Unmarshal(data, &list)
+	}
+	if err != nil {
+		return ServerList{}, err
+	}
+	return list, nil
+}
+
+// dropIgnored removes the servers the config of settings says to ignore, if any.
+func (l *ServerList) dropIgnored(settings FetchSettings) {
+	if settings.Config == nil {
+		return
+	}
+	ignored := map[string]bool{}
+	for _, id := range settings.Config.ServerConfig.IgnoredIDs() {
+		ignored[strconv.Itoa(id)] = true
+	}
+	servers := make([]*Server, 0, len(l.Servers))
+	for _, s := range l.Servers {
+		if !ignored[s.ID] {
+			servers = append(servers, s)
+		}
+	}
+	l.Servers = servers
+}
+
+// sortByOrigin sorts servers by distance to the origin of settings, or else to user.
+// The order of the list is kept when there is no origin.
+func (l *ServerList) sortByOrigin(user *User, settings FetchSettings) {
+	if settings.NoOrigin {
+		return
+	}
+	if settings.Origin != nil {
+		l.sortByDistance(settings.Origin.Lat, settings.Origin.Lon)
+		return
+	}
+	if user != nil {
 		uLat, _ := strconv.ParseFloat(user.Lat, 64)
 		uLon, _ := strconv.ParseFloat(user.Lon, 64)
-		server.Distance = distance(sLat, sLon, uLat, uLon)
+		l.sortByDistance(uLat, uLon)
 	}
+}
 
-	// Sort by distance
-	sort.Sort(ByDistance{list.Servers})
-
-	if len(list.Servers) <= 0 {
-		return list, fmt.Errorf("unable to retrieve server list from %v", speedTestServersUrl)
+// sortByDistance calculates the distance of servers to the given location and sorts them by it.
+// Servers without location, as those of LibreSpeed lists, are left without distance and follow
+// the others in their order.
+func (l *ServerList) sortByDistance(lat, lon float64) {
+	// Calculate distance
+	for _, server := range l.Servers {
+		if sLat, sLon, ok := server.location(); ok {
+			server.Distance = distance(sLat, sLon, lat, lon)
+		}
 	}
 
-	return list, nil
+	sort.SliceStable(l.Servers, func(i, j int) bool {
+		_, _, iLocated := l.Servers[i].location()
+		_, _, jLocated := l.Servers[j].location()
+		if iLocated != jLocated {
+			return iLocated
+		}
+		return l.Servers[i].Distance < l.Servers[j].Distance
+	})
+}
+
+// location returns the coordinates of s, and whether it has any.
+func (s *Server) location() (lat, lon float64, ok bool) {
+	lat, latErr := strconv.ParseFloat(s.Lat, 64)
+	lon, lonErr := strconv.ParseFloat(s.Lon, 64)
+	return lat, lon, latErr == nil && lonErr == nil
 }
 
 func distance(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
@@ -113,21 +225,69 @@ func distance(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
 	return radius * math.Acos(x)
 }
 
-// FindServer finds server by serverID
+// MatchMode decides how requested server IDs missing from a ServerList are treated.
+type MatchMode int
+
+// Modes of matching server IDs
+const (
+	// MatchFallback returns the nearest server when none of the IDs matches.
+	MatchFallback MatchMode = iota
+	// MatchStrict fails with a *ServerNotFoundError when any of the IDs is missing.
+	MatchStrict
+	// MatchPartial returns the servers found along with a *ServerNotFoundError
+	// naming the missing IDs.
+	MatchPartial
+)
+
+// ServerNotFoundError is returned when requested server IDs are not in a ServerList.
+type ServerNotFoundError struct {
+	IDs []int
+}
+
+func (e *ServerNotFoundError) Error() string {
+	ids := make([]string, len(e.IDs))
+	for i, id := range e.IDs {
+		ids[i] = strconv.Itoa(id)
+	}
+	return fmt.Sprintf("server id(s) not found: %s", strings.Join(ids, ", "))
+}
+
+// FindServer finds server by serverID, the nearest server is returned if none matches.
 func (l *ServerList) FindServer(serverID []int) (Servers, error) {
+	return l.FindServerWithMode(serverID, MatchFallback)
+}
+
+// FindServerWithMode finds server by serverID, treating missing IDs according to mode.
+// The nearest server is returned if no ID is requested.
+func (l *ServerList) FindServerWithMode(serverID []int, mode MatchMode) (Servers, error) {
 	servers := Servers{}
 
 	if len(l.Servers) <= 0 {
 		return servers, errors.New("no servers available")
 	}
 
+	missing := []int{}
 	for _, sid := range serverID {
+		found := false
 		for _, s := range l.Servers {
 			id, _ := strconv.Atoi(s.ID)
 			if sid == id {
 				servers = append(servers, s)
+				found = true
 			}
 		}
+		if !found {
+			missing = append(missing, sid)
+		}
+	}
+
+	if len(missing) > 0 {
+		switch mode {
+		case MatchStrict:
+			return Servers{}, &ServerNotFoundError{IDs: missing}
+		case MatchPartial:
+			return servers, &ServerNotFoundError{IDs: missing}
+		}
 	}
 
 	if len(servers) == 0 {
@@ -165,5 +325,5 @@ func (s *Server) String() string {
 
 // CheckResultValid checks that results are logical given UL and DL speeds
 func (s Server) CheckResultValid() bool {
-	return !(s.DLSpeed*100 < s.ULSpeed) || !(s.DLSpeed > s.ULSpeed*100)
+	return validSpeeds(s.DLSpeed, s.ULSpeed)
 }
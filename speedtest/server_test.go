@@ -1,16 +1,18 @@
 package speedtest
 
 import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestFetchServerList(t *testing.T) {
-	// Create a Resty Client
-	client := resty.New()
+	client := &http.Client{}
 
 	user := User{
 		IP:  "111.111.111.111",
@@ -27,8 +29,7 @@ func TestFetchServerList(t *testing.T) {
 func TestFetchServerListWithFakeResponse(t *testing.T) {
 	defer httpmock.DeactivateAndReset()
 
-	// Create a Resty Client
-	client := resty.New()
+	client := &http.Client{}
 
 	// fake response
 	resp := `<settings>
@@ -38,7 +39,7 @@ func TestFetchServerListWithFakeResponse(t *testing.T) {
 	</settings>`
 
 	httpmock.Activate()
-	httpmock.ActivateNonDefault(client.GetClient())
+	httpmock.ActivateNonDefault(client)
 	httpmock.RegisterResponder("GET", speedTestServersUrl, fakeResponder(200, resp, "application/xml"))
 
 	user := User{
@@ -54,6 +55,7 @@ func TestFetchServerListWithFakeResponse(t *testing.T) {
 	assert.Equal(t, "http://fake.com:8080/speedtest/upload.php", serverList.Servers[0].URL)
 	assert.Equal(t, "新北", serverList.Servers[0].Name)
 	assert.Equal(t, "Taiwan", serverList.Servers[0].Country)
+	assert.Equal(t, "TW", serverList.Servers[0].CC)
 	assert.Equal(t, "大新店", serverList.Servers[0].Sponsor)
 	assert.Equal(t, "14652", serverList.Servers[0].ID)
 	assert.Equal(t, "fake.com:8080", serverList.Servers[0].Host)
@@ -64,14 +66,13 @@ func TestFetchServerListWithFakeResponse(t *testing.T) {
 func TestFetchServerListWithEmptyResponse(t *testing.T) {
 	defer httpmock.DeactivateAndReset()
 
-	// Create a Resty Client
-	client := resty.New()
+	client := &http.Client{}
 
 	// fake response
 	resp := `<settings></settings>`
 
 	httpmock.Activate()
-	httpmock.ActivateNonDefault(client.GetClient())
+	httpmock.ActivateNonDefault(client)
 	httpmock.RegisterResponder("GET", speedTestServersUrl, fakeResponder(200, resp, "application/xml"))
 
 	user := User{
@@ -90,8 +91,7 @@ func TestFetchServerListWithEmptyResponse(t *testing.T) {
 func TestFetchServerListWithStatus404(t *testing.T) {
 	defer httpmock.DeactivateAndReset()
 
-	// Create a Resty Client
-	client := resty.New()
+	client := &http.Client{}
 
 	// fake response
 	resp := `<settings>
@@ -101,7 +101,7 @@ func TestFetchServerListWithStatus404(t *testing.T) {
 	</settings>`
 
 	httpmock.Activate()
-	httpmock.ActivateNonDefault(client.GetClient())
+	httpmock.ActivateNonDefault(client)
 	httpmock.RegisterResponder("GET", speedTestServersUrl, fakeResponder(404, resp, "application/xml"))
 
 	user := User{
@@ -134,6 +134,71 @@ func TestDistance(t *testing.T) {
 	assert.LessOrEqual(t, d, 12000.0, "got: %v, expected between 11000 and 12000", d)
 }
 
+func TestSortByDistance(t *testing.T) {
+	list := ServerList{Servers: []*Server{
+		{ID: "a"},
+		{ID: "1", Lat: "35.6800", Lon: "139.6900"},
+		{ID: "b", Lat: "", Lon: "121.5300"},
+		{ID: "2", Lat: "25.0500", Lon: "121.5300"},
+	}}
+	list.sortByDistance(25.0504, 121.5324)
+
+	ids := []string{}
+	for _, s := range list.Servers {
+		ids = append(ids, s.ID)
+	}
+	// servers without location follow the others in their order, without distance
+	assert.Equal(t, []string{"2", "1", "a", "b"}, ids)
+	assert.Less(t, list.Servers[0].Distance, 1.0)
+	assert.Greater(t, list.Servers[1].Distance, 2000.0)
+	assert.Equal(t, 0.0, list.Servers[2].Distance)
+	assert.Equal(t, 0.0, list.Servers[3].Distance)
+}
+
+func TestLoadServerList(t *testing.T) {
+	dir := t.TempDir()
+	xmlPath := filepath.Join(dir, "servers.xml")
+	jsonPath := filepath.Join(dir, "servers.json")
+	assert.NoError(t, os.WriteFile(xmlPath, []byte(`<settings>
+	<servers>
+	<server url="http://far.local:8080/upload.php" lat="40" lon="140" name="Far" cc="JP" id="2" host="far.local:8080"/>
+	<server url="http://near.local:8080/upload.php" lat="25" lon="121.5" name="Near" cc="TW" id="1" host="near.local:8080"/>
+	</servers>
+	</settings>`), 0644))
+	assert.NoError(t, os.WriteFile(jsonPath, []byte(`{"servers": [
+		{"url": "http://far.local:8080/upload.php", "lat": "40", "lon": "140", "name": "Far", "cc": "JP", "id": "2", "host": "far.local:8080"},
+		{"url": "http://near.local:8080/upload.php", "lat": "25", "lon": "121.5", "name": "Near", "cc": "TW", "id": "1", "host": "near.local:8080"}
+	]}`), 0644))
+
+	user := &User{Lat: "25.0504", Lon: "121.5324"}
+	for _, path := range []string{xmlPath, jsonPath} {
+		list, err := LoadServerList(path, user)
+		assert.NoError(t, err, "unexpected error %v", err)
+		assert.Equal(t, 2, len(list.Servers))
+		assert.Equal(t, "1", list.Servers[0].ID)
+		assert.Equal(t, "Near", list.Servers[0].Name)
+		assert.Equal(t, "TW", list.Servers[0].CC)
+		assert.Equal(t, "near.local:8080", list.Servers[0].Host)
+		assert.Equal(t, distance(25, 121.5, 25.0504, 121.5324), list.Servers[0].Distance)
+
+		list, err = LoadServerList(path, nil)
+		assert.NoError(t, err, "unexpected error %v", err)
+		assert.Equal(t, "2", list.Servers[0].ID, "order should be kept without user")
+
+		list, err = LoadServerList(path, nil, WithOrigin(35.6, 139.7))
+		assert.NoError(t, err, "unexpected error %v", err)
+		assert.Equal(t, "2", list.Servers[0].ID, "servers should be sorted around the origin")
+	}
+
+	_, err := LoadServerList(filepath.Join(dir, "missing.xml"), user)
+	assert.Error(t, err)
+
+	emptyPath := filepath.Join(dir, "empty.json")
+	assert.NoError(t, os.WriteFile(emptyPath, []byte(`{"servers": []}`), 0644))
+	_, err = LoadServerList(emptyPath, user)
+	assert.Error(t, err)
+}
+
 func TestFindServer(t *testing.T) {
 	servers := []*Server{
 		{
@@ -168,8 +233,49 @@ func TestFindServer(t *testing.T) {
 	assert.Equal(t, "1", s[1].ID, "unexpected server ID. got: %v, expected: '1'", s[1].ID)
 }
 
+func TestFindServerWithMode(t *testing.T) {
+	serverList := ServerList{Servers: []*Server{{ID: "1"}, {ID: "2"}, {ID: "3"}}}
+
+	s, err := serverList.FindServerWithMode([]int{}, MatchStrict)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 1, len(s))
+	assert.Equal(t, "1", s[0].ID)
+
+	s, err = serverList.FindServerWithMode([]int{3, 2}, MatchStrict)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 2, len(s))
+
+	s, err = serverList.FindServerWithMode([]int{2, 4, 5}, MatchStrict)
+	var notFound *ServerNotFoundError
+	assert.True(t, errors.As(err, &notFound), "unexpected error %v", err)
+	assert.Equal(t, []int{4, 5}, notFound.IDs)
+	assert.Equal(t, "server id(s) not found: 4, 5", err.Error())
+	assert.Equal(t, 0, len(s))
+
+	s, err = serverList.FindServerWithMode([]int{2, 4}, MatchPartial)
+	assert.True(t, errors.As(err, &notFound), "unexpected error %v", err)
+	assert.Equal(t, []int{4}, notFound.IDs)
+	assert.Equal(t, 1, len(s))
+	assert.Equal(t, "2", s[0].ID)
+
+	s, err = serverList.FindServerWithMode([]int{4}, MatchPartial)
+	assert.True(t, errors.As(err, &notFound), "unexpected error %v", err)
+	assert.Equal(t, 0, len(s))
+
+	s, err = serverList.FindServerWithMode([]int{4}, MatchFallback)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 1, len(s))
+	assert.Equal(t, "1", s[0].ID)
+}
+
 func TestNewServer(t *testing.T) {
 	server := NewServer("http://fake.com:8080/speedtest/upload.php")
 	assert.Equal(t, "http://fake.com:8080/speedtest/upload.php", server.URL)
 	assert.Equal(t, "http://fake.com:8080/speedtest/upload.php", server.Host)
 }
+
+func TestServerCheckResultValid(t *testing.T) {
+	assert.True(t, Server{DLSpeed: 100, ULSpeed: 10}.CheckResultValid())
+	assert.False(t, Server{DLSpeed: 1000, ULSpeed: 1}.CheckResultValid())
+	assert.False(t, Server{DLSpeed: 1, ULSpeed: 1000}.CheckResultValid())
+}
@@ -0,0 +1,144 @@
+package speedtest
+
+import "time"
+
+// TestSettings holds the parameters download and upload tests are run with.
+type TestSettings struct {
+	// Protocol is how tests talk to the server.
+	Protocol Protocol `json:"protocol"`
+	// DownloadDuration bounds the main download phase by wall-clock time instead of
+	// a fixed number of requests. Zero keeps the fixed workload.
+	DownloadDuration time.Duration `json:"download_duration"`
+	// UploadDuration does the same for the main upload phase.
+	UploadDuration time.Duration `json:"upload_duration"`
+	// DownloadStreams is the number of parallel streams of the main download phase.
+	// Zero lets the warm up speed decide.
+	DownloadStreams int `json:"download_streams"`
+	// UploadStreams does the same for the main upload phase.
+	UploadStreams int `json:"upload_streams"`
+	// SampleInterval is the interval throughput samples are recorded at. Zero disables sampling.
+	SampleInterval time.Duration `json:"sample_interval"`
+	// PingCount is the number of latency samples taken by the ping test.
+	PingCount int `json:"ping_count"`
+	// PingInterval is the pause between two latency samples.
+	PingInterval time.Duration `json:"ping_interval"`
+	// LoadedLatencyInterval is the interval latency is probed at while download and
+	// upload run. Zero disables loaded latency.
+	LoadedLatencyInterval time.Duration `json:"loaded_latency_interval"`
+	// UDP runs a UDP test after the ping test of Run, if set.
+	UDP *UDPSettings `json:"udp,omitempty"`
+	// Observer receives the events of running tests, if set. Progress events are
+	// reported every SampleInterval.
+	Observer Observer `json:"-"`
+}
+
+// TestOption configures TestSettings.
+type TestOption func(*TestSettings)
+
+// WithProtocol runs tests over the given protocol.
+func WithProtocol(p Protocol) TestOption {
+	return func(s *TestSettings) {
+		s.Protocol = p
+	}
+}
+
+// WithDuration runs the main download and upload phases for d each.
+func WithDuration(d time.Duration) TestOption {
+	return func(s *TestSettings) {
+		s.DownloadDuration = d
+		s.UploadDuration = d
+	}
+}
+
+// WithDownloadDuration runs the main download phase for d.
+func WithDownloadDuration(d time.Duration) TestOption {
+	return func(s *TestSettings) {
+		s.DownloadDuration = d
+	}
+}
+
+// WithUploadDuration runs the main upload phase for d.
+func WithUploadDuration(d time.Duration) TestOption {
+	return func(s *TestSettings) {
+		s.UploadDuration = d
+	}
+}
+
+// WithStreams runs the main download and upload phases with n parallel streams each.
+func WithStreams(n int) TestOption {
+	return func(s *TestSettings) {
+		s.DownloadStreams = n
+		s.UploadStreams = n
+	}
+}
+
+// WithDownloadStreams runs the main download phase with n parallel streams.
+func WithDownloadStreams(n int) TestOption {
+	return func(s *TestSettings) {
+		s.DownloadStreams = n
+	}
+}
+
+// WithUploadStreams runs the main upload phase with n parallel streams.
+func WithUploadStreams(n int) TestOption {
+	return func(s *TestSettings) {
+		s.UploadStreams = n
+	}
+}
+
+// WithSampleInterval records throughput samples every d, zero disables sampling.
+func WithSampleInterval(d time.Duration) TestOption {
+	return func(s *TestSettings) {
+		s.SampleInterval = d
+	}
+}
+
+// WithPingCount takes n latency samples in the ping test.
+func WithPingCount(n int) TestOption {
+	return func(s *TestSettings) {
+		s.PingCount = n
+	}
+}
+
+// WithPingInterval pauses d between two latency samples.
+func WithPingInterval(d time.Duration) TestOption {
+	return func(s *TestSettings) {
+		s.PingInterval = d
+	}
+}
+
+// WithLoadedLatencyInterval probes latency every d while download and upload run, zero disables probing.
+func WithLoadedLatencyInterval(d time.Duration) TestOption {
+	return func(s *TestSettings) {
+		s.LoadedLatencyInterval = d
+	}
+}
+
+// WithUDP runs a UDP test with the given options after the ping test of Run.
+func WithUDP(opts ...UDPOption) TestOption {
+	return func(s *TestSettings) {
+		udp := newUDPSettings(opts...)
+		s.UDP = &udp
+	}
+}
+
+// WithObserver reports the events of running tests to o.
+func WithObserver(o Observer) TestOption {
+	return func(s *TestSettings) {
+		s.Observer = o
+	}
+}
+
+// newTestSettings returns the default settings with opts applied.
+func newTestSettings(opts ...TestOption) TestSettings {
+	s := TestSettings{
+		Protocol:              ProtocolHTTP,
+		SampleInterval:        100 * time.Millisecond,
+		PingCount:             3,
+		LoadedLatencyInterval: 200 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
+}
@@ -0,0 +1,31 @@
+package speedtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTestSettings(t *testing.T) {
+	s := newTestSettings()
+	assert.Equal(t, TestSettings{Protocol: ProtocolHTTP, SampleInterval: 100 * time.Millisecond, PingCount: 3, LoadedLatencyInterval: 200 * time.Millisecond}, s)
+
+	s = newTestSettings(WithProtocol(ProtocolTCP))
+	assert.Equal(t, ProtocolTCP, s.Protocol)
+
+	s = newTestSettings(WithSampleInterval(0))
+	assert.Equal(t, time.Duration(0), s.SampleInterval)
+
+	s = newTestSettings(WithDuration(10 * time.Second))
+	assert.Equal(t, 10*time.Second, s.DownloadDuration)
+	assert.Equal(t, 10*time.Second, s.UploadDuration)
+
+	s = newTestSettings(WithStreams(8), WithUploadStreams(2))
+	assert.Equal(t, 8, s.DownloadStreams)
+	assert.Equal(t, 2, s.UploadStreams)
+
+	s = newTestSettings(WithDuration(10*time.Second), WithUploadDuration(5*time.Second))
+	assert.Equal(t, 10*time.Second, s.DownloadDuration)
+	assert.Equal(t, 5*time.Second, s.UploadDuration)
+}
@@ -0,0 +1,248 @@
+package speedtest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protocol selects how tests talk to a server.
+type Protocol string
+
+// Protocols tests can use
+const (
+	// ProtocolHTTP requests latency.txt, random images and upload.php over HTTP.
+	ProtocolHTTP Protocol = "http"
+	// ProtocolTCP speaks the line based socket protocol of OoklaServer to Server.Host.
+	ProtocolTCP Protocol = "tcp"
+	// ProtocolLibreSpeed requests empty.php and garbage.php of a LibreSpeed server, which
+	// servers of LibreSpeed lists use whatever the protocol of the settings.
+	ProtocolLibreSpeed Protocol = "librespeed"
+)
+
+// socketConn is a connection speaking the socket protocol of OoklaServer.
+type socketConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	ctx  context.Context
+	done chan struct{}
+}
+
+// dialSocket connects to host and greets the server. The connection is interrupted
+// as soon as ctx is done.
+func dialSocket(ctx context.Context, host string) (*socketConn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &socketConn{conn: conn, r: bufio.NewReader(conn), ctx: ctx, done: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			// unblock pending reads and writes
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-c.done:
+		}
+	}()
+
+	reply, err := c.command("HI")
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(reply, "HELLO") {
+		c.Close()
+		return nil, fmt.Errorf("unexpected greeting %q from %v", reply, host)
+	}
+	return c, nil
+}
+
+// Close says goodbye to the server and closes the connection.
+func (c *socketConn) Close() error {
+	close(c.done)
+	if c.ctx.Err() == nil {
+		_, _ = io.WriteString(c.conn, "QUIT\n")
+	}
+	return c.conn.Close()
+}
+
+// command sends a line and returns the line replied.
+func (c *socketConn) command(line string) (string, error) {
+	if _, err := io.WriteString(c.conn, line+"\n"); err != nil {
+		return "", c.err(err)
+	}
+	reply, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", c.err(err)
+	}
+	return strings.TrimSpace(reply), nil
+}
+
+// err reports the context error instead of the one of an interrupted connection.
+func (c *socketConn) err(err error) error {
+	if c.ctx.Err() != nil {
+		return c.ctx.Err()
+	}
+	return err
+}
+
+// ping returns the round trip time of a PING command.
+func (c *socketConn) ping() (time.Duration, error) {
+	sTime := time.Now()
+	reply, err := c.command("PING " + strconv.FormatInt(sTime.UnixNano()/int64(time.Millisecond), 10))
+	if err != nil {
+		return 0, err
+	}
+	if !strings.HasPrefix(reply, "PONG") {
+		return 0, fmt.Errorf("unexpected reply %q to PING", reply)
+	}
+	return time.Since(sTime), nil
+}
+
+// download receives size bytes, counting them in cnt.
+func (c *socketConn) download(size int64, cnt *counter) error {
+	if _, err := io.WriteString(c.conn, "DOWNLOAD "+strconv.FormatInt(size, 10)+"\n"); err != nil {
+		return c.err(err)
+	}
+	if _, err := io.CopyN(cnt, c.r, size); err != nil {
+		return c.err(err)
+	}
+	return nil
+}
+
+// upload sends size bytes, including the command, counting them in cnt once the server acknowledged them.
+func (c *socketConn) upload(size int64, cnt *counter) error {
+	header := "UPLOAD " + strconv.FormatInt(size, 10) + " 0\n"
+	for size < int64(len(header))+1 {
+		size = int64(len(header)) + 1
+		header = "UPLOAD " + strconv.FormatInt(size, 10) + " 0\n"
+	}
+
+	if _, err := io.WriteString(c.conn, header); err != nil {
+		return c.err(err)
+	}
+	body := io.MultiReader(io.LimitReader(newPayload(size), size-int64(len(header))-1), strings.NewReader("\n"))
+	if _, err := io.Copy(c.conn, body); err != nil {
+		return c.err(err)
+	}
+
+	reply, err := c.r.ReadString('\n')
+	if err != nil {
+		return c.err(err)
+	}
+	if !strings.HasPrefix(reply, "OK") {
+		return fmt.Errorf("unexpected reply %q to UPLOAD", strings.TrimSpace(reply))
+	}
+	// the bytes are only counted once the server acknowledged them
+	cnt.Add(size)
+	return nil
+}
+
+// socketHost returns the address the socket protocol is spoken on, which is Server.Host
+// or the host of it when it is a URL, as for servers given by NewServer.
+func (s *Server) socketHost() string {
+	if strings.Contains(s.Host, "://") {
+		if u, err := url.Parse(s.Host); err == nil {
+			return u.Host
+		}
+	}
+	return s.Host
+}
+
+// socketBackend speaks the socket protocol of OoklaServer to host, on a connection per request.
+type socketBackend struct {
+	host string
+}
+
+func (b *socketBackend) endpoint() string {
+	return "tcp://" + b.host
+}
+
+// pingSamples returns the round trip times of settings.PingCount PING commands on one connection.
+func (b *socketBackend) pingSamples(ctx context.Context, settings TestSettings, events *emitter) ([]time.Duration, error) {
+	count := settings.PingCount
+	if count < 1 {
+		count = 1
+	}
+
+	c, err := dialSocket(ctx, b.host)
+	if err != nil {
+		return nil, checkCancelled(ctx, "ping", err)
+	}
+	defer c.Close()
+
+	samples := make([]time.Duration, 0, count)
+	for i := 0; i < count; i++ {
+		if i > 0 && settings.PingInterval > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(settings.PingInterval):
+			}
+		}
+
+		rtt, err := c.ping()
+		if err != nil {
+			return nil, checkCancelled(ctx, "ping", err)
+		}
+
+		events.emit(Event{Type: EventRequestDone, Latency: rtt})
+		samples = append(samples, rtt)
+	}
+
+	return samples, nil
+}
+
+// latencyProbe returns the round trip time of a PING command on a connection of its own.
+func (b *socketBackend) latencyProbe() (probeFunc, func()) {
+	probe := func(ctx context.Context) (time.Duration, error) {
+		c, err := dialSocket(ctx, b.host)
+		if err != nil {
+			return 0, err
+		}
+		defer c.Close()
+		return c.ping()
+	}
+	return probe, func() {}
+}
+
+func (b *socketBackend) download() (transferFunc, transferFunc) {
+	request := b.transfer(socketDownloadRequest)
+	return request, request
+}
+
+func (b *socketBackend) upload() (transferFunc, transferFunc) {
+	request := b.transfer(socketUploadRequest)
+	return request, request
+}
+
+// transfer returns a transferFunc moving the bytes of a request of weight w
+// on a connection of its own.
+func (b *socketBackend) transfer(transfer func(c *socketConn, w int, cnt *counter) error) transferFunc {
+	return func(ctx context.Context, w int, cnt *counter) error {
+		c, err := dialSocket(ctx, b.host)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		return transfer(c, w, cnt)
+	}
+}
+
+// socketDownloadRequest receives as many bytes as the random image of weight w.
+func socketDownloadRequest(c *socketConn, w int, cnt *counter) error {
+	size := int64(dlSizes[w])
+	return c.download(2*size*size, cnt)
+}
+
+// socketUploadRequest sends as many bytes as the upload of weight w.
+func socketUploadRequest(c *socketConn, w int, cnt *counter) error {
+	return c.upload(int64(ulSizes[w])*1000, cnt)
+}
@@ -0,0 +1,201 @@
+package speedtest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSocketServer speaks the socket protocol of OoklaServer on a local port.
+type fakeSocketServer struct {
+	ln       net.Listener
+	uploaded int64
+	// stall makes the server never answer DOWNLOAD commands.
+	stall bool
+}
+
+func newFakeSocketServer(t *testing.T, stall bool) *fakeSocketServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSocketServer{ln: ln, stall: stall}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeSocketServer) Host() string {
+	return f.ln.Addr().String()
+}
+
+func (f *fakeSocketServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "HI":
+			io.WriteString(conn, "HELLO 2.9 (2.9.0) fake\n")
+		case "PING":
+			io.WriteString(conn, fmt.Sprintf("PONG %d\n", time.Now().UnixNano()/int64(time.Millisecond)))
+		case "DOWNLOAD":
+			if f.stall {
+				continue
+			}
+			n, _ := strconv.ParseInt(fields[1], 10, 64)
+			io.WriteString(conn, "DOWNLOAD ")
+			if _, err := io.CopyN(conn, fillReader{}, n-int64(len("DOWNLOAD "))-1); err != nil {
+				return
+			}
+			io.WriteString(conn, "\n")
+		case "UPLOAD":
+			n, _ := strconv.ParseInt(fields[1], 10, 64)
+			read, err := io.CopyN(io.Discard, r, n-int64(len(line)))
+			atomic.AddInt64(&f.uploaded, read+int64(len(line)))
+			if err != nil {
+				return
+			}
+			io.WriteString(conn, fmt.Sprintf("OK %d %d\n", n, time.Now().UnixNano()/int64(time.Millisecond)))
+		case "QUIT":
+			return
+		default:
+			io.WriteString(conn, "ERROR\n")
+		}
+	}
+}
+
+// fillReader is an endless stream of x.
+type fillReader struct{}
+
+func (fillReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 'x'
+	}
+	return len(b), nil
+}
+
+func TestSocketConn(t *testing.T) {
+	f := newFakeSocketServer(t, false)
+
+	c, err := dialSocket(context.Background(), f.Host())
+	assert.NoError(t, err, "unexpected error %v", err)
+	defer c.Close()
+
+	rtt, err := c.ping()
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Greater(t, int64(rtt), int64(0))
+
+	cnt := &counter{}
+	assert.NoError(t, c.download(100000, cnt))
+	assert.Equal(t, int64(100000), cnt.Load())
+
+	cnt = &counter{}
+	assert.NoError(t, c.upload(100000, cnt))
+	assert.Equal(t, int64(100000), cnt.Load())
+	assert.Equal(t, int64(100000), atomic.LoadInt64(&f.uploaded))
+
+	// the size is raised to fit the command
+	cnt = &counter{}
+	assert.NoError(t, c.upload(1, cnt))
+	assert.Equal(t, int64(len("UPLOAD 14 0\n")+1), cnt.Load())
+}
+
+func TestSocketConnWithoutServer(t *testing.T) {
+	f := newFakeSocketServer(t, false)
+	host := f.Host()
+	f.ln.Close()
+
+	_, err := dialSocket(context.Background(), host)
+	assert.Error(t, err)
+}
+
+func TestSocketConnCancelled(t *testing.T) {
+	f := newFakeSocketServer(t, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c, err := dialSocket(ctx, f.Host())
+	assert.NoError(t, err, "unexpected error %v", err)
+	defer c.Close()
+
+	err = c.download(100000, &counter{})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error %v", err)
+}
+
+func TestPingTestWithSocketProtocol(t *testing.T) {
+	f := newFakeSocketServer(t, false)
+
+	server := Server{Host: f.Host()}
+	err := server.PingTest(&http.Client{}, WithProtocol(ProtocolTCP), WithPingCount(5))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Greater(t, int64(server.Latency), int64(0))
+}
+
+func TestRunWithSocketProtocol(t *testing.T) {
+	f := newFakeSocketServer(t, false)
+
+	server := Server{Host: f.Host(), URL: "http://unused.com/upload.php", ID: "1"}
+	res, err := server.Run(&http.Client{}, WithProtocol(ProtocolTCP), WithDuration(100*time.Millisecond), WithUploadDuration(500*time.Millisecond), WithUploadStreams(2))
+	assert.NoError(t, err, "unexpected error %v", err)
+
+	assert.Equal(t, "tcp://"+f.Host(), res.Ping.URL)
+	assert.Equal(t, "tcp://"+f.Host(), res.Download.URL)
+	assert.Equal(t, "tcp://"+f.Host(), res.Upload.URL)
+	assert.Greater(t, res.Download.Speed, 0.0)
+	assert.Greater(t, res.Upload.Speed, 0.0)
+	assert.Greater(t, res.Upload.Bytes, int64(0))
+	assert.Greater(t, len(res.Download.Latency.Samples), 0, "latency should be probed over the socket protocol")
+}
+
+func TestBestServerWithSocketProtocol(t *testing.T) {
+	f := newFakeSocketServer(t, false)
+
+	// the URL is not served, candidates answer over the socket protocol only
+	up := Server{Host: f.Host(), URL: "http://unused.invalid/upload.php", ID: "1"}
+	down := Server{Host: "127.0.0.1:1", URL: "http://unused.invalid/upload.php", ID: "2"}
+	list := ServerList{Servers: []*Server{&down, &up}}
+
+	best, _, err := list.BestServer(&http.Client{}, WithProbeProtocol(ProtocolTCP))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, &up, best)
+
+	best, _, err = NewClient(WithTestOptions(WithProtocol(ProtocolTCP))).BestServer(&list)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, &up, best, "the client should probe over the protocol of its tests")
+}
+
+func TestSocketHost(t *testing.T) {
+	s := Server{Host: "fake.com:8080"}
+	assert.Equal(t, "fake.com:8080", s.socketHost())
+
+	s = NewServer("http://fake.com:8080/speedtest/upload.php")
+	assert.Equal(t, "fake.com:8080", s.socketHost())
+	assert.Equal(t, "tcp://fake.com:8080", (&socketBackend{host: s.socketHost()}).endpoint())
+}
@@ -0,0 +1,43 @@
+package speedtest
+
+import (
+	"net/http"
+)
+
+// Transport sends the HTTP requests of fetches and tests. *http.Client implements it,
+// other implementations may wrap one or carry requests over a protocol of their own.
+// Tests send all of their requests through the Transport they are given.
+type Transport interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// separateConnections returns a transport sending requests on connections of its own rather
+// than those of t, when t is an http.Client, and a func releasing them.
+func separateConnections(t Transport) (Transport, func()) {
+	hc, ok := t.(*http.Client)
+	if !ok {
+		return t, func() {}
+	}
+
+	rt := hc.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	transport, ok := rt.(*http.Transport)
+	if !ok {
+		return t, func() {}
+	}
+
+	clone := *hc
+	transport = transport.Clone()
+	clone.Transport = transport
+	return &clone, transport.CloseIdleConnections
+}
+
+// newHTTPClient returns an http.Client with connections of its own.
+func newHTTPClient() *http.Client {
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		return &http.Client{Transport: transport.Clone()}
+	}
+	return &http.Client{}
+}
@@ -0,0 +1,62 @@
+package speedtest
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+type transportFunc func(req *http.Request) (*http.Response, error)
+
+func (f transportFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// wrappingTransport sends requests through an http.Client, counting them.
+type wrappingTransport struct {
+	client *http.Client
+	calls  int32
+}
+
+func (t *wrappingTransport) Do(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.calls, 1)
+	return t.client.Do(req)
+}
+
+func TestTestsUseTransport(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	client := newFakeServer()
+	wrapping := &wrappingTransport{client: client}
+
+	server := Server{URL: "http://fake.com/upload.php"}
+	_, err := server.Run(wrapping, WithDuration(100*time.Millisecond), WithPingCount(2))
+	assert.NoError(t, err, "unexpected error %v", err)
+	// ping, warm up and main phases of both tests
+	assert.GreaterOrEqual(t, int(atomic.LoadInt32(&wrapping.calls)), 2+2+2+2)
+	assert.Equal(t, int(atomic.LoadInt32(&wrapping.calls)), httpmock.GetTotalCallCount())
+}
+
+func TestSeparateConnections(t *testing.T) {
+	hc := &http.Client{Transport: &http.Transport{}}
+	sep, closeSep := separateConnections(hc)
+	defer closeSep()
+	if assert.IsType(t, &http.Client{}, sep) {
+		assert.NotEqual(t, hc.Transport, sep.(*http.Client).Transport)
+	}
+
+	// other transports are used as they are, even those wrapping an http.Client
+	wrapping := &wrappingTransport{client: &http.Client{Transport: &http.Transport{}}}
+	sep, closeSep = separateConnections(wrapping)
+	defer closeSep()
+	assert.Equal(t, wrapping, sep)
+
+	var custom Transport = transportFunc(func(req *http.Request) (*http.Response, error) { return nil, errors.New("unused") })
+	sep, closeSep = separateConnections(custom)
+	defer closeSep()
+	assert.IsType(t, custom, sep)
+}
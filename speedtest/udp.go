@@ -0,0 +1,277 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// udpMagic marks the datagrams of a UDP test, so that stray ones are ignored. The datagrams
+// are of a format of this package, which any UDP echo server returns as they are. OoklaServer
+// does not echo them.
+const udpMagic = "SPDT"
+
+// udpHeaderSize is the size of the magic, sequence number and send time of a datagram.
+const udpHeaderSize = len(udpMagic) + 4 + 8
+
+// UDPSettings holds the parameters of a UDP test.
+type UDPSettings struct {
+	// EchoAddr is the address of the UDP echo server datagrams are sent to, the host of
+	// the server by default.
+	EchoAddr string `json:"echo_addr,omitempty"`
+	// Count is the number of datagrams sent.
+	Count int `json:"count"`
+	// Interval is the pause between two datagrams.
+	Interval time.Duration `json:"interval"`
+	// Size is the size of a datagram in bytes.
+	Size int `json:"size"`
+	// Timeout is how long replies are awaited after the last datagram was sent.
+	Timeout time.Duration `json:"timeout"`
+}
+
+// UDPOption configures UDPSettings.
+type UDPOption func(*UDPSettings)
+
+// WithUDPEchoAddr sends datagrams to the UDP echo server at addr instead of the host of the server.
+func WithUDPEchoAddr(addr string) UDPOption {
+	return func(s *UDPSettings) {
+		s.EchoAddr = addr
+	}
+}
+
+// WithUDPCount sends n datagrams.
+func WithUDPCount(n int) UDPOption {
+	return func(s *UDPSettings) {
+		s.Count = n
+	}
+}
+
+// WithUDPInterval pauses d between two datagrams.
+func WithUDPInterval(d time.Duration) UDPOption {
+	return func(s *UDPSettings) {
+		s.Interval = d
+	}
+}
+
+// WithUDPSize sends datagrams of n bytes, raised to the size of their header.
+func WithUDPSize(n int) UDPOption {
+	return func(s *UDPSettings) {
+		s.Size = n
+	}
+}
+
+// WithUDPTimeout awaits replies for d after the last datagram was sent.
+func WithUDPTimeout(d time.Duration) UDPOption {
+	return func(s *UDPSettings) {
+		s.Timeout = d
+	}
+}
+
+func newUDPSettings(opts ...UDPOption) UDPSettings {
+	s := UDPSettings{
+		Count:    100,
+		Interval: 20 * time.Millisecond,
+		Size:     64,
+		Timeout:  time.Second,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	if s.Size < udpHeaderSize {
+		s.Size = udpHeaderSize
+	}
+	return s
+}
+
+// UDPResult is the outcome of a UDP test.
+type UDPResult struct {
+	// URL is the endpoint datagrams were sent to.
+	URL      string `json:"url"`
+	Sent     int    `json:"sent"`
+	Received int    `json:"received"`
+	// Loss is the percentage of datagrams which were not echoed.
+	Loss float64 `json:"loss"`
+	// OutOfOrder counts echoes received after one of a later datagram.
+	OutOfOrder int `json:"out_of_order"`
+	// Duplicates counts echoes received more than once.
+	Duplicates int `json:"duplicates"`
+	// RTT describes the round trip times of datagrams, in the order they were sent.
+	RTT      LatencyStats  `json:"rtt"`
+	Duration time.Duration `json:"duration"`
+}
+
+// UDPTest sends a stream of sequenced datagrams to a UDP echo server, at the host of the server
+// unless WithUDPEchoAddr is given, and reports their loss, reordering, duplication and round trip
+// times. Servers of speedtest.net do not echo them, see ServeUDPEcho.
+func (s *Server) UDPTest(opts ...UDPOption) (*UDPResult, error) {
+	return s.UDPTestContext(context.Background(), opts...)
+}
+
+// UDPTestContext is UDPTest observing the given context.
+func (s *Server) UDPTestContext(ctx context.Context, opts ...UDPOption) (*UDPResult, error) {
+	res, err := s.udp(ctx, newUDPSettings(opts...), newEmitter(nil, s))
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// udpTracker records the echoes of a UDP test.
+type udpTracker struct {
+	mu         sync.Mutex
+	rtts       map[uint32]time.Duration
+	highest    uint32
+	outOfOrder int
+	duplicates int
+}
+
+func (t *udpTracker) record(seq uint32, rtt time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.rtts[seq]; ok {
+		t.duplicates++
+		return
+	}
+	if len(t.rtts) > 0 && seq < t.highest {
+		t.outOfOrder++
+	}
+	if seq > t.highest {
+		t.highest = seq
+	}
+	t.rtts[seq] = rtt
+}
+
+// udp runs a UDP test without modifying s.
+func (s *Server) udp(ctx context.Context, settings UDPSettings, events *emitter) (UDPResult, error) {
+	events.startPhase(PhaseUDP, 0)
+	res, err := runUDP(ctx, s.udpEchoAddr(settings), settings)
+	if err != nil {
+		err = checkCancelled(ctx, "udp", err)
+		events.endPhase(Event{Err: err})
+		return UDPResult{}, err
+	}
+	events.endPhase(Event{Latency: res.RTT.Median})
+	return res, nil
+}
+
+// udpEchoAddr returns the address datagrams of a UDP test of s are sent to.
+func (s *Server) udpEchoAddr(settings UDPSettings) string {
+	if settings.EchoAddr != "" {
+		return settings.EchoAddr
+	}
+	return s.socketHost()
+}
+
+func runUDP(ctx context.Context, addr string, settings UDPSettings) (UDPResult, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return UDPResult{}, err
+	}
+	defer conn.Close()
+
+	sTime := time.Now()
+	tracker := &udpTracker{rtts: map[uint32]time.Duration{}}
+	received := make(chan struct{})
+	go func() {
+		defer close(received)
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				// such as ICMP port unreachable, later datagrams may still be echoed
+				continue
+			}
+			if n < udpHeaderSize || string(buf[:len(udpMagic)]) != udpMagic {
+				continue
+			}
+			seq := binary.BigEndian.Uint32(buf[len(udpMagic):])
+			sent := int64(binary.BigEndian.Uint64(buf[len(udpMagic)+4:]))
+			tracker.record(seq, time.Duration(time.Now().Sub(sTime).Nanoseconds()-sent))
+		}
+	}()
+
+	packet := make([]byte, settings.Size)
+	copy(packet, udpMagic)
+	sent := 0
+	for i := 0; i < settings.Count && ctx.Err() == nil; i++ {
+		if i > 0 && settings.Interval > 0 {
+			select {
+			case <-ctx.Done():
+				continue
+			case <-time.After(settings.Interval):
+			}
+		}
+		binary.BigEndian.PutUint32(packet[len(udpMagic):], uint32(i))
+		binary.BigEndian.PutUint64(packet[len(udpMagic)+4:], uint64(time.Since(sTime).Nanoseconds()))
+		if _, err := conn.Write(packet); err == nil {
+			sent++
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(settings.Timeout):
+	}
+	conn.Close()
+	<-received
+	if err := ctx.Err(); err != nil {
+		return UDPResult{}, err
+	}
+
+	return tracker.result("udp://"+addr, sent, time.Since(sTime)), nil
+}
+
+func (t *udpTracker) result(url string, sent int, duration time.Duration) UDPResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seqs := make([]uint32, 0, len(t.rtts))
+	for seq := range t.rtts {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	rtts := make([]time.Duration, len(seqs))
+	for i, seq := range seqs {
+		rtts[i] = t.rtts[seq]
+	}
+
+	res := UDPResult{
+		URL:        url,
+		Sent:       sent,
+		Received:   len(rtts),
+		OutOfOrder: t.outOfOrder,
+		Duplicates: t.duplicates,
+		RTT:        newLatencyStats(rtts),
+		Duration:   duration,
+	}
+	if sent > 0 {
+		res.Loss = float64(sent-len(rtts)) / float64(sent) * 100
+	}
+	return res
+}
+
+// ServeUDPEcho echoes every datagram received on conn back to its sender, which makes
+// conn a stand-in server for UDP tests. It returns once conn is closed.
+func ServeUDPEcho(conn net.PacketConn) error {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if _, err := conn.WriteTo(buf[:n], addr); err != nil && errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newUDPEcho(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ServeUDPEcho(conn)
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().String()
+}
+
+// newImpairedUDPEcho echoes datagrams but drops 3 and 7, duplicates 5, and swaps 10 and 11.
+func newImpairedUDPEcho(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 64*1024)
+		var held []byte
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			switch binary.BigEndian.Uint32(buf[len(udpMagic):]) {
+			case 3, 7:
+			case 5:
+				conn.WriteTo(buf[:n], addr)
+				conn.WriteTo(buf[:n], addr)
+			case 10:
+				held = append([]byte{}, buf[:n]...)
+			case 11:
+				conn.WriteTo(buf[:n], addr)
+				conn.WriteTo(held, addr)
+			default:
+				conn.WriteTo(buf[:n], addr)
+			}
+		}
+	}()
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().String()
+}
+
+func TestUDPTest(t *testing.T) {
+	addr := newUDPEcho(t)
+
+	server := Server{Host: addr}
+	res, err := server.UDPTest(WithUDPCount(20), WithUDPInterval(time.Millisecond), WithUDPTimeout(200*time.Millisecond))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "udp://"+addr, res.URL)
+	assert.Equal(t, 20, res.Sent)
+	assert.Equal(t, 20, res.Received)
+	assert.Equal(t, 0.0, res.Loss)
+	assert.Equal(t, 0, res.OutOfOrder)
+	assert.Equal(t, 0, res.Duplicates)
+	assert.Equal(t, 20, len(res.RTT.Samples))
+	assert.Greater(t, int64(res.RTT.Min), int64(0))
+}
+
+func TestUDPTestWithImpairedEcho(t *testing.T) {
+	addr := newImpairedUDPEcho(t)
+
+	server := Server{Host: "unused.com:8080"}
+	res, err := server.UDPTest(WithUDPEchoAddr(addr), WithUDPCount(20), WithUDPInterval(time.Millisecond), WithUDPTimeout(200*time.Millisecond))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 20, res.Sent)
+	assert.Equal(t, 18, res.Received)
+	assert.Equal(t, 10.0, res.Loss)
+	assert.Equal(t, 1, res.OutOfOrder)
+	assert.Equal(t, 1, res.Duplicates)
+	assert.Equal(t, 18, len(res.RTT.Samples))
+}
+
+func TestUDPTestWithoutServer(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err, "unexpected error %v", err)
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	server := Server{Host: addr}
+	res, err := server.UDPTest(WithUDPCount(5), WithUDPInterval(time.Millisecond), WithUDPTimeout(50*time.Millisecond))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, 0, res.Received)
+	assert.Equal(t, 100.0, res.Loss)
+}
+
+func TestUDPTestCancelled(t *testing.T) {
+	addr := newUDPEcho(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	server := Server{Host: addr}
+	sTime := time.Now()
+	_, err := server.UDPTestContext(ctx, WithUDPCount(1000))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error %v", err)
+	assert.Less(t, int64(time.Since(sTime)), int64(time.Second))
+}
+
+func TestRunWithUDP(t *testing.T) {
+	defer httpmock.DeactivateAndReset()
+	client := newFakeServer()
+	addr := newUDPEcho(t)
+
+	server := Server{URL: "http://fake.com/upload.php", Host: addr}
+	rec := &recorder{}
+	res, err := server.Run(client, WithDuration(50*time.Millisecond), withoutLoadedLatency,
+		WithUDP(WithUDPCount(5), WithUDPInterval(time.Millisecond), WithUDPTimeout(50*time.Millisecond)), WithObserver(rec.observe))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.NotNil(t, res.UDP)
+	assert.Equal(t, 5, res.UDP.Received)
+	assert.Equal(t, 1, len(rec.filter(EventPhaseEnd, PhaseUDP)))
+
+	res, err = server.Run(client, WithDuration(50*time.Millisecond), withoutLoadedLatency)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Nil(t, res.UDP)
+
+	// a UDP test which can not be run is recorded and skipped
+	server.ID = "1"
+	res, err = server.Run(client, WithDuration(50*time.Millisecond), withoutLoadedLatency, WithUDP(WithUDPEchoAddr("127.0.0.1:invalid")))
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Nil(t, res.UDP)
+	assert.Greater(t, res.Download.Speed, 0.0)
+	if assert.Equal(t, 1, len(res.Failovers)) {
+		assert.Equal(t, "1", res.Failovers[0].ServerID)
+		assert.Equal(t, "udp://127.0.0.1:invalid", res.Failovers[0].URL)
+		assert.Equal(t, PhaseUDP, res.Failovers[0].Phase)
+	}
+}
+
+func TestNewUDPSettings(t *testing.T) {
+	s := newUDPSettings()
+	assert.Equal(t, UDPSettings{Count: 100, Interval: 20 * time.Millisecond, Size: 64, Timeout: time.Second}, s)
+
+	s = newUDPSettings(WithUDPSize(1))
+	assert.Equal(t, udpHeaderSize, s.Size)
+}
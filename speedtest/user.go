@@ -3,8 +3,6 @@ package speedtest
 import (
 	"context"
 	"fmt"
-
-	"github.com/go-resty/resty/v2"
 )
 
 const speedTestConfigUrl = "https://www.speedtest.net/speedtest-config.php"
@@ -19,39 +17,24 @@ type User struct {
 	Country string `xml:"country,attr"`
 }
 
-// Users for decode xml
+// Users for decode xml, see Config for the whole document
 type Users struct {
 	Users []User `xml:"client"`
 }
 
 // FetchUserInfo returns information about caller determined by speedtest.net
-func FetchUserInfo(client *resty.Client) (*User, error) {
-	return FetchUserInfoContext(context.Background(), client)
+func FetchUserInfo(client Transport, opts ...FetchOption) (*User, error) {
+	return FetchUserInfoContext(context.Background(), client, opts...)
 }
 
 // FetchUserInfoContext returns information about caller determined by speedtest.net, observing the given context.
-func FetchUserInfoContext(ctx context.Context, client *resty.Client) (*User, error) {
-	var users Users
-
-	resp, err := client.R().
-		SetContext(ctx).
-		SetResult(&users).
-		Get(speedTestConfigUrl)
-
+func FetchUserInfoContext(ctx context.Context, client Transport, opts ...FetchOption) (*User, error) {
+	cfg, err := FetchConfigContext(ctx, client, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode() != 200 {
-		return nil, fmt.Errorf("unexpected status code %v while fetching user information from %v", resp.StatusCode(), speedTestConfigUrl)
-	}
-
-	if len(users.Users) == 0 {
-		return nil, fmt.Errorf("failed to fetch user information from %v", speedTestConfigUrl)
-	}
-
-	// Only return the first item
-	return &users.Users[0], nil
+	return cfg.Client, nil
 }
 
 // String representation of User
@@ -2,11 +2,11 @@ package speedtest
 
 import (
 	"net"
+	"net/http"
 	"strconv"
 	"strings"
 	"testing"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
 )
@@ -19,8 +19,7 @@ func fakeResponder(s int, c string, ct string) httpmock.Responder {
 }
 
 func TestFetchUserInfo(t *testing.T) {
-	// Create a Resty Client
-	client := resty.New()
+	client := &http.Client{}
 
 	user, err := FetchUserInfo(client)
 	assert.NoError(t, err, "unexpected error %v", err)
@@ -44,8 +43,7 @@ func TestFetchUserInfo(t *testing.T) {
 func TestFetchUserInfoWithFakeResponse(t *testing.T) {
 	defer httpmock.DeactivateAndReset()
 
-	// Create a Resty Client
-	client := resty.New()
+	client := &http.Client{}
 
 	// fake response
 	resp := `<settings>
@@ -65,7 +63,7 @@ func TestFetchUserInfoWithFakeResponse(t *testing.T) {
 	</settings>`
 
 	httpmock.Activate()
-	httpmock.ActivateNonDefault(client.GetClient())
+	httpmock.ActivateNonDefault(client)
 	httpmock.RegisterResponder("GET", speedTestConfigUrl, fakeResponder(200, resp, "application/xml"))
 
 	user, err := FetchUserInfo(client)
@@ -80,14 +78,13 @@ func TestFetchUserInfoWithFakeResponse(t *testing.T) {
 func TestFetchUserInfoWithEmptyResponse(t *testing.T) {
 	defer httpmock.DeactivateAndReset()
 
-	// Create a Resty Client
-	client := resty.New()
+	client := &http.Client{}
 
 	// fake response
 	resp := `<settings></settings>`
 
 	httpmock.Activate()
-	httpmock.ActivateNonDefault(client.GetClient())
+	httpmock.ActivateNonDefault(client)
 	httpmock.RegisterResponder("GET", speedTestConfigUrl, fakeResponder(200, resp, "application/xml"))
 
 	user, err := FetchUserInfo(client)
@@ -99,8 +96,7 @@ func TestFetchUserInfoWithEmptyResponse(t *testing.T) {
 func TestFetchUserInfoWithStatus404(t *testing.T) {
 	defer httpmock.DeactivateAndReset()
 
-	// Create a Resty Client
-	client := resty.New()
+	client := &http.Client{}
 
 	// fake response
 	resp := `<settings>
@@ -120,7 +116,7 @@ func TestFetchUserInfoWithStatus404(t *testing.T) {
 	</settings>`
 
 	httpmock.Activate()
-	httpmock.ActivateNonDefault(client.GetClient())
+	httpmock.ActivateNonDefault(client)
 	httpmock.RegisterResponder("GET", speedTestConfigUrl, fakeResponder(404, resp, "application/xml"))
 
 	user, err := FetchUserInfo(client)
@@ -0,0 +1,153 @@
+package speedtesttest
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Endpoint names a kind of request served by a Server.
+type Endpoint string
+
+// Endpoints of a Server
+const (
+	EndpointConfig     Endpoint = "config"
+	EndpointServerList Endpoint = "server_list"
+	EndpointLatency    Endpoint = "latency"
+	EndpointDownload   Endpoint = "download"
+	EndpointUpload     Endpoint = "upload"
+)
+
+// Fault makes requests to a Server fail. Status, Truncate and Stall are exclusive, in that order.
+type Fault struct {
+	// Endpoint restricts the fault to an endpoint, all of them when empty.
+	Endpoint Endpoint
+	// ServerID restricts the fault to an entry of the list, all entries when empty.
+	// Faults restricted to an entry do not apply to the config and server list endpoints.
+	ServerID string
+	// Times restricts the fault to the first requests it applies to, all of them when 0.
+	Times int
+
+	// Status replies the given status code instead of the usual reply.
+	Status int
+	// Truncate announces the usual reply but closes the connection halfway through it.
+	Truncate bool
+	// Stall never replies, until the request is cancelled or the server closed.
+	Stall bool
+}
+
+// SetFaults replaces the faults injected so far. The first fault applying to a request decides
+// how it fails, requests no fault applies to are served as usual.
+func (s *Server) SetFaults(faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+	for i := range faults {
+		f := faults[i]
+		s.faults = append(s.faults, &f)
+	}
+}
+
+// fault returns the fault applying to a request, counting it.
+func (s *Server) fault(id string, endpoint Endpoint) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.faults {
+		if f.Endpoint != "" && f.Endpoint != endpoint {
+			continue
+		}
+		if f.ServerID != "" && f.ServerID != id {
+			continue
+		}
+
+		applied := *f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+		return &applied
+	}
+	return nil
+}
+
+// serve fails a request, calling serve for the usual reply when it is needed.
+func (f *Fault) serve(w http.ResponseWriter, r *http.Request, closed <-chan struct{}, serve func(http.ResponseWriter)) {
+	switch {
+	case f.Status != 0:
+		http.Error(w, http.StatusText(f.Status), f.Status)
+	case f.Truncate:
+		tw := &truncatedWriter{w: w}
+		serve(tw)
+		tw.finish()
+		// the server closes connections replying less than announced
+	case f.Stall:
+		select {
+		case <-r.Context().Done():
+		case <-closed:
+		}
+	default:
+		serve(w)
+	}
+}
+
+// errTruncated stops the writer of a truncated reply.
+var errTruncated = errors.New("reply truncated")
+
+// truncatedWriter passes on the headers of a reply and half of its body. The body of replies
+// announcing their length is streamed, that of others is buffered to announce it.
+type truncatedWriter struct {
+	w       http.ResponseWriter
+	status  int
+	length  int64
+	written int64
+	buf     *bytes.Buffer
+}
+
+func (t *truncatedWriter) Header() http.Header {
+	return t.w.Header()
+}
+
+func (t *truncatedWriter) WriteHeader(status int) {
+	if t.status != 0 {
+		return
+	}
+	t.status = status
+	n, err := strconv.ParseInt(t.w.Header().Get("Content-Length"), 10, 64)
+	if err != nil {
+		t.buf = &bytes.Buffer{}
+		return
+	}
+	t.length = n
+	t.w.WriteHeader(status)
+}
+
+func (t *truncatedWriter) Write(p []byte) (int, error) {
+	t.WriteHeader(http.StatusOK)
+	if t.buf != nil {
+		return t.buf.Write(p)
+	}
+
+	if left := t.length/2 - t.written; int64(len(p)) > left {
+		n, _ := t.w.Write(p[:left])
+		t.written += int64(n)
+		return n, errTruncated
+	}
+	n, err := t.w.Write(p)
+	t.written += int64(n)
+	return n, err
+}
+
+// finish writes the first half of a buffered reply.
+func (t *truncatedWriter) finish() {
+	t.WriteHeader(http.StatusOK)
+	if t.buf == nil {
+		return
+	}
+	body := t.buf.Bytes()
+	t.w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	t.w.WriteHeader(t.status)
+	_, _ = t.w.Write(body[:len(body)/2])
+}
@@ -0,0 +1,96 @@
+package speedtesttest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jonascheng/speedtest-go/speedtest"
+)
+
+var quick = []speedtest.TestOption{speedtest.WithDuration(100 * time.Millisecond), speedtest.WithLoadedLatencyInterval(0)}
+
+func TestFaultStatus(t *testing.T) {
+	ts := NewServer(WithFault(Fault{Endpoint: EndpointConfig, Status: 503}))
+	defer ts.Close()
+
+	_, err := speedtest.FetchConfig(&http.Client{}, ts.FetchOptions()...)
+	assert.Error(t, err)
+
+	_, err = ts.Servers()[0].Run(&http.Client{}, quick...)
+	assert.NoError(t, err, "other endpoints should not fail, got %v", err)
+}
+
+func TestFaultServerID(t *testing.T) {
+	ts := NewServer(
+		WithEntries(Entry{ID: "1"}, Entry{ID: "2"}),
+		WithFault(Fault{ServerID: "1", Endpoint: EndpointLatency, Status: 500}),
+	)
+	defer ts.Close()
+
+	res, err := ts.Servers().RunWithFailover(&http.Client{}, quick...)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "2", res.Server.ID)
+	assert.Greater(t, len(res.Failovers), 0)
+}
+
+func TestFaultTimes(t *testing.T) {
+	ts := NewServer(WithFault(Fault{Endpoint: EndpointLatency, Status: 500, Times: 1}))
+	defer ts.Close()
+
+	s := ts.Servers()[0]
+	assert.Error(t, s.PingTest(&http.Client{}))
+	assert.NoError(t, s.PingTest(&http.Client{}))
+}
+
+func TestFaultTruncate(t *testing.T) {
+	ts := NewServer(WithFault(Fault{Endpoint: EndpointDownload, Truncate: true}))
+	defer ts.Close()
+
+	_, err := ts.Servers()[0].Run(&http.Client{}, quick...)
+	assert.Error(t, err)
+
+	ts.SetFaults(Fault{Endpoint: EndpointServerList, Truncate: true})
+	_, err = speedtest.FetchServerList(&http.Client{}, nil, append(ts.FetchOptions(), speedtest.WithoutOrigin())...)
+	assert.Error(t, err)
+}
+
+func TestFaultTruncateStreams(t *testing.T) {
+	ts := NewServer(WithFault(Fault{Endpoint: EndpointDownload, Truncate: true}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/1/random4000x4000.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(io.Discard, resp.Body)
+	assert.Error(t, err, "a truncated reply should fail")
+	assert.Equal(t, int64(2*4000*4000), resp.ContentLength)
+	assert.Equal(t, int64(4000*4000), n)
+}
+
+func TestFaultStall(t *testing.T) {
+	ts := NewServer(WithFault(Fault{Endpoint: EndpointUpload, Stall: true}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err := ts.Servers()[0].RunContext(ctx, &http.Client{}, quick...)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error %v", err)
+}
+
+func TestSetFaults(t *testing.T) {
+	ts := NewServer(WithFault(Fault{Status: 500}))
+	defer ts.Close()
+
+	s := ts.Servers()[0]
+	assert.Error(t, s.PingTest(&http.Client{}))
+	ts.SetFaults()
+	assert.NoError(t, s.PingTest(&http.Client{}))
+}
@@ -0,0 +1,246 @@
+// Package speedtesttest provides a fake speedtest.net, for tests of code using the speedtest package.
+//
+// A Server answers speedtest-config.php and speedtest-servers-static.php, and serves every
+// entry of its server list under a path of its own, with the link and faults configured:
+//
+//	ts := speedtesttest.NewServer(speedtesttest.WithEntries(
+//		speedtesttest.Entry{ID: "1", Link: server.Link{Delay: 20 * time.Millisecond}},
+//	))
+//	defer ts.Close()
+//
+//	res, err := ts.Servers()[0].Run(http.DefaultClient)
+package speedtesttest
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/jonascheng/speedtest-go/server"
+	"github.com/jonascheng/speedtest-go/speedtest"
+)
+
+// Entry is a server of the list served by a Server.
+type Entry struct {
+	ID      string
+	Name    string
+	Country string
+	CC      string
+	Sponsor string
+	Lat     string
+	Lon     string
+	// Link is the network emulated between the entry and its clients.
+	Link server.Link
+}
+
+// Server is a fake speedtest.net listening on a local port.
+type Server struct {
+	// URL is the base URL of the server, ex: http://127.0.0.1:4242
+	URL string
+
+	ts      *httptest.Server
+	config  speedtest.Config
+	entries []Entry
+	servers map[string]*server.Server
+	closed  chan struct{}
+	// closeOnce makes Close idempotent, as that of httptest.Server
+	closeOnce sync.Once
+
+	mu     sync.Mutex
+	faults []*Fault
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithUser makes speedtest-config.php return user.
+func WithUser(user speedtest.User) Option {
+	return func(s *Server) {
+		s.config.Client = &user
+	}
+}
+
+// WithConfig makes speedtest-config.php return cfg, along with the user of WithUser if given.
+func WithConfig(cfg speedtest.Config) Option {
+	return func(s *Server) {
+		client := s.config.Client
+		s.config = cfg
+		if client != nil {
+			s.config.Client = client
+		}
+	}
+}
+
+// WithEntries makes the server list hold entries, in the given order.
+func WithEntries(entries ...Entry) Option {
+	return func(s *Server) {
+		s.entries = entries
+	}
+}
+
+// WithFault injects f from the start, see Server.SetFaults.
+func WithFault(f Fault) Option {
+	return func(s *Server) {
+		s.faults = append(s.faults, &f)
+	}
+}
+
+// NewServer starts a Server with opts applied. By default its user is located in Taipei,
+// and its list holds a single entry of id 1 without link emulation.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		config: speedtest.Config{
+			Client: &speedtest.User{IP: "127.0.0.1", Lat: "25.0504", Lon: "121.5324", Isp: "Fake ISP", Country: "TW"},
+		},
+		entries: []Entry{{ID: "1", Name: "Taipei", Country: "Taiwan", CC: "TW", Sponsor: "Fake", Lat: "25.0504", Lon: "121.5324"}},
+		servers: map[string]*server.Server{},
+		closed:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	for _, e := range s.entries {
+		s.servers[e.ID] = server.New(server.WithLink(e.Link))
+	}
+
+	s.ts = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.ts.URL
+	return s
+}
+
+// Close unblocks stalled requests and shuts the server down. Later calls do nothing.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.ts.Close()
+	})
+}
+
+// ConfigURL is the URL of speedtest-config.php.
+func (s *Server) ConfigURL() string {
+	return s.URL + "/speedtest-config.php"
+}
+
+// ServerListURL is the URL of speedtest-servers-static.php.
+func (s *Server) ServerListURL() string {
+	return s.URL + "/speedtest-servers-static.php"
+}
+
+// FetchOptions make FetchConfig, FetchUserInfo and FetchServerList fetch from the server.
+func (s *Server) FetchOptions() []speedtest.FetchOption {
+	return []speedtest.FetchOption{
+		speedtest.WithConfigURL(s.ConfigURL()),
+		speedtest.WithServerListURL(s.ServerListURL()),
+	}
+}
+
+// Servers returns the entries of the list, in order, ready to be tested.
+func (s *Server) Servers() speedtest.Servers {
+	host := strings.TrimPrefix(s.URL, "http://")
+	svrs := speedtest.Servers{}
+	for _, e := range s.entries {
+		svrs = append(svrs, &speedtest.Server{
+			URL:     s.URL + "/" + url.PathEscape(e.ID) + "/upload.php",
+			Lat:     e.Lat,
+			Lon:     e.Lon,
+			Name:    e.Name,
+			Country: e.Country,
+			CC:      e.CC,
+			Sponsor: e.Sponsor,
+			ID:      e.ID,
+			Host:    host,
+		})
+	}
+	return svrs
+}
+
+// xmlServer is a server of speedtest-servers-static.php.
+type xmlServer struct {
+	URL     string `xml:"url,attr"`
+	Lat     string `xml:"lat,attr"`
+	Lon     string `xml:"lon,attr"`
+	Name    string `xml:"name,attr"`
+	Country string `xml:"country,attr"`
+	CC      string `xml:"cc,attr"`
+	Sponsor string `xml:"sponsor,attr"`
+	ID      string `xml:"id,attr"`
+	Host    string `xml:"host,attr"`
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	id, endpoint := s.route(r.URL.Path)
+	if endpoint == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if f := s.fault(id, endpoint); f != nil {
+		f.serve(w, r, s.closed, func(w http.ResponseWriter) { s.serveEndpoint(w, r, id, endpoint) })
+		return
+	}
+	s.serveEndpoint(w, r, id, endpoint)
+}
+
+// route returns the entry id and the endpoint of path, if any.
+func (s *Server) route(path string) (string, Endpoint) {
+	switch path {
+	case "/speedtest-config.php":
+		return "", EndpointConfig
+	case "/speedtest-servers-static.php":
+		return "", EndpointServerList
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	id, err := url.PathUnescape(parts[0])
+	if _, ok := s.servers[id]; err != nil || !ok {
+		return "", ""
+	}
+	switch {
+	case parts[1] == "latency.txt":
+		return id, EndpointLatency
+	case parts[1] == "upload.php":
+		return id, EndpointUpload
+	case strings.HasPrefix(parts[1], "random") && strings.HasSuffix(parts[1], ".jpg"):
+		return id, EndpointDownload
+	}
+	return "", ""
+}
+
+func (s *Server) serveEndpoint(w http.ResponseWriter, r *http.Request, id string, endpoint Endpoint) {
+	switch endpoint {
+	case EndpointConfig:
+		s.serveXML(w, struct {
+			XMLName xml.Name `xml:"settings"`
+			speedtest.Config
+		}{Config: s.config})
+	case EndpointServerList:
+		list := struct {
+			XMLName xml.Name    `xml:"settings"`
+			Servers []xmlServer `xml:"servers>server"`
+		}{}
+		for _, svr := range s.Servers() {
+			list.Servers = append(list.Servers, xmlServer{
+				URL: svr.URL, Lat: svr.Lat, Lon: svr.Lon, Name: svr.Name, Country: svr.Country,
+				CC: svr.CC, Sponsor: svr.Sponsor, ID: svr.ID, Host: svr.Host,
+			})
+		}
+		s.serveXML(w, list)
+	default:
+		s.servers[id].ServeHTTP(w, r)
+	}
+}
+
+func (s *Server) serveXML(w http.ResponseWriter, v interface{}) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	_, _ = w.Write(append([]byte(xml.Header), data...))
+}
@@ -0,0 +1,97 @@
+package speedtesttest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jonascheng/speedtest-go/server"
+	"github.com/jonascheng/speedtest-go/speedtest"
+)
+
+func TestFetch(t *testing.T) {
+	ts := NewServer(
+		WithUser(speedtest.User{IP: "10.0.0.1", Lat: "35.68", Lon: "139.69", Isp: "Test ISP", Country: "JP"}),
+		WithConfig(speedtest.Config{ServerConfig: speedtest.ServerConfig{ThreadCount: 2, IgnoreIDs: "3"}}),
+		WithEntries(
+			Entry{ID: "1", Name: "Taipei", CC: "TW", Lat: "25.05", Lon: "121.53"},
+			Entry{ID: "2", Name: "Tokyo", CC: "JP", Lat: "35.68", Lon: "139.69"},
+			Entry{ID: "3", Name: "Osaka", CC: "JP", Lat: "34.69", Lon: "135.50"},
+		),
+	)
+	defer ts.Close()
+
+	client := &http.Client{}
+	cfg, err := speedtest.FetchConfig(client, ts.FetchOptions()...)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "10.0.0.1", cfg.Client.IP)
+	assert.Equal(t, "Test ISP", cfg.Client.Isp)
+	assert.Equal(t, 2, cfg.ServerConfig.ThreadCount)
+
+	list, err := speedtest.FetchServerList(client, cfg.Client, append(ts.FetchOptions(), speedtest.WithConfig(cfg))...)
+	assert.NoError(t, err, "unexpected error %v", err)
+	if assert.Equal(t, 2, len(list.Servers)) {
+		// sorted around the user in Tokyo, Osaka being ignored
+		assert.Equal(t, "2", list.Servers[0].ID)
+		assert.Equal(t, "1", list.Servers[1].ID)
+		assert.Equal(t, ts.URL+"/2/upload.php", list.Servers[0].URL)
+		assert.Equal(t, ts.Servers()[1].Host, list.Servers[0].Host)
+	}
+}
+
+func TestServers(t *testing.T) {
+	ts := NewServer()
+	defer ts.Close()
+
+	svrs := ts.Servers()
+	if assert.Equal(t, 1, len(svrs)) {
+		assert.Equal(t, "1", svrs[0].ID)
+		assert.Equal(t, ts.URL+"/1/upload.php", svrs[0].URL)
+	}
+
+	// uploads are counted once acknowledged, two of them fit the window
+	res, err := svrs[0].Run(&http.Client{},
+		speedtest.WithDuration(100*time.Millisecond),
+		speedtest.WithUploadDuration(500*time.Millisecond),
+		speedtest.WithUploadStreams(2),
+		speedtest.WithLoadedLatencyInterval(0),
+	)
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Greater(t, res.Download.Speed, 0.0)
+	assert.Greater(t, res.Upload.Speed, 0.0)
+}
+
+func TestEntryLink(t *testing.T) {
+	ts := NewServer(WithEntries(
+		Entry{ID: "1", Link: server.Link{Delay: 100 * time.Millisecond}},
+		Entry{ID: "2", Link: server.Link{Delay: 10 * time.Millisecond}},
+	))
+	defer ts.Close()
+
+	list := speedtest.ServerList{Servers: ts.Servers()}
+	best, _, err := list.BestServer(&http.Client{})
+	assert.NoError(t, err, "unexpected error %v", err)
+	assert.Equal(t, "2", best.ID)
+}
+
+func TestNotFound(t *testing.T) {
+	ts := NewServer()
+	defer ts.Close()
+
+	for _, p := range []string{"/", "/2/latency.txt", "/1/index.html", "/latency.txt"} {
+		resp, err := http.Get(ts.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, 404, resp.StatusCode, p)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	ts := NewServer()
+	ts.Close()
+	assert.NotPanics(t, ts.Close)
+}